@@ -5,7 +5,6 @@ import (
 	"math"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/tidwall/gjson"
@@ -27,11 +26,38 @@ var (
 	birthYearIndex = NewSafeIndex()
 	fnameIndex     = NewSafeIndex()
 	snameIndex     = NewSafeIndex()
-	sexIndex       = NewSafeIndex()
 	interestsIndex = NewSafeIndex()
-	likeeIndex     = NewSafeIndex() // who liked this user
-	emailIndex     = NewSafeIndex()
+	likeeIndex     = NewReverseLikeIndex() // who liked this user
+	emailIndex     = NewOrderedIndex(utils.StringComparator)
 	phoneIndex     = NewSafeIndex()
+	birthIndex     = NewOrderedIndex(timestampKeyComparator)
+	joinedIndex    = NewOrderedIndex(timestampKeyComparator)
+	fnameTrie      = NewPrefixTrie()
+	snameTrie      = NewPrefixTrie()
+	premiumIndex   = NewPremiumIndex()
+
+	// indexes by derived fields
+	emailDomainIndex = NewSafeIndex()
+	phoneCodeIndex   = NewSafeIndex()
+	joinedYearIndex  = NewSafeIndex()
+
+	// case-insensitive indexes by foldName
+	normalizedCityIndex  = NewSafeIndex()
+	normalizedFnameIndex = NewSafeIndex()
+	normalizedSnameIndex = NewSafeIndex()
+	fnameLengths         = NewNameLengthIndex()
+	snameLengths         = NewNameLengthIndex()
+
+	// bitmaps for low-cardinality predicates
+	sexBitmap     = NewBitmapIndex()
+	statusBitmap  = NewBitmapIndex()
+	notNullBitmap = NewBitmapIndex() // field name -> accounts with non-empty value
+	flagsBitmap   = NewBitmapIndex() // bitmapAll, bitmapPremiumNow
+)
+
+const (
+	bitmapAll        = "all"
+	bitmapPremiumNow = "premium_now"
 )
 
 type Account struct {
@@ -55,204 +81,130 @@ type Account struct {
 	phoneCode    int
 	birthYear    int
 	joinedYear   int
-	likes        map[int]LikesList
-
-	sync.Mutex
+	likes        Likes
 }
 
+// AppendLike is called under the store write lock as any other change of account
 func (acc *Account) AppendLike(likeeId int, likeTs int) {
-	acc.Lock()
-	defer acc.Unlock()
-
-	acc.likes[likeeId] = append(acc.likes[likeeId], likeTs)
+	acc.likes.Append(likeeId, likeTs)
 }
 
 func (acc Account) hasActivePremium(now int64) bool {
 	return acc.Premium["start"] <= int(now) && acc.Premium["finish"] > int(now)
 }
 
-// Update user
-func (acc *Account) Update(changedData map[string]interface{}) {
-	// 11 (w/o likes)
-	if newValue, ok := changedData["interests"]; ok {
-		// delete old value from indexes
-		for _, v := range acc.Interests {
-			interestsIndex.Get(v).(*treemap.Map).Remove(acc.ID)
+func updateBitmap(index *BitmapIndex, key interface{}, id int, isSet bool) {
+	if isSet {
+		index.Add(key, id)
+	} else {
+		index.Remove(key, id)
+	}
+}
+
+// updateDerivedFields recalculates fields which are computed from the stored ones
+func (acc *Account) updateDerivedFields() {
+	acc.emailDomain = ""
+	components := strings.Split(acc.Email, "@")
+	if len(components) > 1 {
+		acc.emailDomain = components[1]
+	}
+
+	acc.phoneCode = 0
+	if phoneParts := strings.SplitN(acc.Phone, "(", 2); len(phoneParts) > 1 {
+		phoneCodeStr := strings.SplitN(phoneParts[1], ")", 2)[0]
+		if phoneCode, err := strconv.Atoi(phoneCodeStr); err == nil {
+			acc.phoneCode = phoneCode
 		}
+	}
+
+	loc, _ := time.LoadLocation("UTC")
+	acc.birthYear = 0
+	if acc.Birth != 0 {
+		tm := time.Unix(int64(acc.Birth), 0)
+		acc.birthYear = tm.In(loc).Year()
+	}
+
+	acc.joinedYear = 0
+	if acc.Joined != 0 {
+		tm := time.Unix(int64(acc.Joined), 0)
+		acc.joinedYear = tm.In(loc).Year()
+	}
+}
+
+// Update user, indexes are updated by the registry
+func (acc *Account) Update(changedData map[string]interface{}) {
+	fields := make([]string, 0, len(changedData))
+	for field := range changedData {
+		fields = append(fields, field)
+	}
+	reindexAccount(acc, fields, func() {
+		acc.applyChanges(changedData)
+	})
+}
 
-		// set new value
+func (acc *Account) applyChanges(changedData map[string]interface{}) {
+	// 12
+	if newValue, ok := changedData["interests"]; ok {
 		acc.interestsMap = make(map[string]struct{})
 		for _, v := range newValue.([]interface{}) {
-			interest := v.(string)
-			acc.interestsMap[interest] = struct{}{}
-			if !interestsIndex.Exists(interest) {
-				interestsIndex.Update(interest, treemap.NewWith(inverseIntComparator))
-			}
-			interestsIndex.Get(interest).(*treemap.Map).Put(acc.ID, acc)
+			acc.interestsMap[v.(string)] = struct{}{}
 		}
 		acc.Interests = nil
 	}
 
 	if newValue, ok := changedData["email"]; ok {
-		// delete old value from indexes
-		emailIndex.Delete(acc.Email)
-		acc.emailDomain = ""
-
-		// set new value
 		acc.Email = newValue.(string)
-		components := strings.Split(acc.Email, "@")
-		if len(components) > 1 {
-			acc.emailDomain = components[1]
-		}
-		emailIndex.Update(acc.Email, struct{}{})
 	}
 
 	if newValue, ok := changedData["status"]; ok {
-		// set new value
 		acc.Status = newValue.(string)
 	}
 
 	if newValue, ok := changedData["phone"]; ok {
-		// delete old value from indexes
-		phoneIndex.Delete(acc.Phone)
-		acc.phoneCode = 0
-
-		// set new value
 		acc.Phone = newValue.(string)
-		phoneCodeStr := strings.SplitN(strings.SplitN(acc.Phone, "(", 2)[1], ")", 2)[0]
-		if phoneCode, err := strconv.Atoi(phoneCodeStr); err == nil {
-			acc.phoneCode = phoneCode
-		}
-		phoneIndex.Update(acc.Phone, struct{}{})
 	}
 
 	if newValue, ok := changedData["birth"]; ok {
-		// delete old value from indexes
-		if birthYearIndex.Exists(acc.birthYear) {
-			birthYearIndex.Get(acc.birthYear).(*treemap.Map).Remove(acc.ID)
-		}
-
 		acc.Birth = newValue.(int)
-		// set new value
-		loc, _ := time.LoadLocation("UTC")
-		tm := time.Unix(int64(acc.Birth), 0)
-		acc.birthYear = tm.In(loc).Year()
-
-		if acc.birthYear > 0 {
-			if !birthYearIndex.Exists(acc.birthYear) {
-				birthYearIndex.Update(acc.birthYear, treemap.NewWith(inverseIntComparator))
-			}
-			birthYearIndex.Get(acc.birthYear).(*treemap.Map).Put(acc.ID, acc)
-		}
 	}
 
 	if newValue, ok := changedData["joined"]; ok {
-		// set new value
 		acc.Joined = newValue.(int)
-		loc, _ := time.LoadLocation("UTC")
-		tm := time.Unix(int64(acc.Joined), 0)
-		acc.joinedYear = tm.In(loc).Year()
 	}
 
-	//FIXME:
-	//if newValue, ok := changedData["likes"]; ok {
-	//}
+	// likes are validated and parsed by the handler
+	if newValue, ok := changedData["likes"]; ok {
+		acc.likes = newValue.(Likes)
+	}
 
 	if newValue, ok := changedData["country"]; ok {
-		// delete old value from indexes
-		if countryIndex.Exists(acc.Country) {
-			countryIndex.Get(acc.Country).(*treemap.Map).Remove(acc.ID)
-		}
-
-		// set new value
 		acc.Country = newValue.(string)
-		if !countryIndex.Exists(acc.Country) {
-			countryIndex.Update(acc.Country, treemap.NewWith(inverseIntComparator))
-		}
-		countryIndex.Get(acc.Country).(*treemap.Map).Put(acc.ID, acc)
 	}
 
 	if newValue, ok := changedData["city"]; ok {
-		// delete old value from indexes
-		if cityIndex.Exists(acc.City) {
-			cityIndex.Get(acc.City).(*treemap.Map).Remove(acc.ID)
-		}
-
-		// set new value
 		acc.City = newValue.(string)
-		if !cityIndex.Exists(acc.City) {
-			cityIndex.Update(acc.City, treemap.NewWith(inverseIntComparator))
-		}
-		cityIndex.Get(acc.City).(*treemap.Map).Put(acc.ID, acc)
 	}
 
 	if newValue, ok := changedData["fname"]; ok {
-		// delete old value from indexes
-		if fnameIndex.Exists(acc.Fname) {
-			fnameIndex.Get(acc.Fname).(*treemap.Map).Remove(acc.ID)
-		}
-
-		// set new value
 		acc.Fname = newValue.(string)
-		if !fnameIndex.Exists(acc.Fname) {
-			fnameIndex.Update(acc.Fname, treemap.NewWith(inverseIntComparator))
-		}
-		fnameIndex.Get(acc.Fname).(*treemap.Map).Put(acc.ID, acc)
 	}
 
 	if newValue, ok := changedData["sname"]; ok {
-		// delete old value from indexes
-		if snameIndex.Exists(acc.Sname) {
-			snameIndex.Get(acc.Sname).(*treemap.Map).Remove(acc.ID)
-		}
-
-		// set new value
 		acc.Sname = newValue.(string)
-		if !snameIndex.Exists(acc.Sname) {
-			snameIndex.Update(acc.Sname, treemap.NewWith(inverseIntComparator))
-		}
-		snameIndex.Get(acc.Sname).(*treemap.Map).Put(acc.ID, acc)
 	}
 
 	if newValue, ok := changedData["sex"]; ok {
-		// delete old value from indexes
-		if sexIndex.Exists(acc.Sex) {
-			sexIndex.Get(acc.Sex).(*treemap.Map).Remove(acc.ID)
-		}
-
-		// set new value
 		acc.Sex = newValue.(string)
-		if !sexIndex.Exists(acc.Sex) {
-			sexIndex.Update(acc.Sex, treemap.NewWith(inverseIntComparator))
-		}
-		sexIndex.Get(acc.Sex).(*treemap.Map).Put(acc.ID, acc)
 	}
 
 	if newValue, ok := changedData["premium"]; ok {
 		acc.Premium = make(map[string]int, 0)
-		// set new value
 		data := newValue.(map[string]interface{})
 		acc.Premium["start"] = int(data["start"].(float64))
 		acc.Premium["finish"] = int(data["finish"].(float64))
 	}
-}
-
-type LikesList []int
 
-func (list LikesList) getTimestamp() int {
-	var ts int
-
-	if len(list) > 1 {
-		var total = 0
-		for _, value := range list {
-			total += value
-		}
-		ts = total / int(len(list))
-	} else {
-		ts = list[0]
-	}
-
-	return ts
+	acc.updateDerivedFields()
 }
 
 func NewAccount(acc Account) {
@@ -260,96 +212,22 @@ func NewAccount(acc Account) {
 		acc.interestsMap = make(map[string]struct{})
 		for _, interest := range acc.Interests {
 			acc.interestsMap[interest] = struct{}{}
-			if !interestsIndex.Exists(interest) {
-				interestsIndex.Update(interest, treemap.NewWith(inverseIntComparator))
-			}
-			interestsIndex.Get(interest).(*treemap.Map).Put(acc.ID, &acc)
 		}
 		acc.Interests = nil
 	}
 
-	if acc.Email != "" {
-		components := strings.Split(acc.Email, "@")
-		if len(components) > 1 {
-			acc.emailDomain = components[1]
-		}
-		emailIndex.Update(acc.Email, 1)
-	}
-
-	if acc.Phone != "" {
-		phoneCodeStr := strings.SplitN(strings.SplitN(acc.Phone, "(", 2)[1], ")", 2)[0]
-		if phoneCode, err := strconv.Atoi(phoneCodeStr); err == nil {
-			acc.phoneCode = phoneCode
-		}
-		phoneIndex.Update(acc.Phone, struct{}{})
-	}
-
-	if acc.Birth != 0 {
-		loc, _ := time.LoadLocation("UTC")
-		tm := time.Unix(int64(acc.Birth), 0)
-		acc.birthYear = tm.In(loc).Year()
-	}
-
-	if acc.Joined != 0 {
-		loc, _ := time.LoadLocation("UTC")
-		tm := time.Unix(int64(acc.Joined), 0)
-		acc.joinedYear = tm.In(loc).Year()
-	}
+	acc.updateDerivedFields()
 
 	if len(acc.TempLikes) > 0 {
-		acc.likes = make(map[int]LikesList, 0)
 		gjson.ParseBytes(acc.TempLikes).ForEach(func(key, value gjson.Result) bool {
 			like := value.Map()
-			likeId := int(like["id"].Int())
-
-			acc.AppendLike(likeId, int(like["ts"].Int()))
-
-			if !likeeIndex.Exists(likeId) {
-				likeeIndex.Update(likeId, treemap.NewWith(inverseIntComparator))
-			}
-			likeeIndex.Get(likeId).(*treemap.Map).Put(acc.ID, &acc)
+			acc.AppendLike(int(like["id"].Int()), int(like["ts"].Int()))
 			return true
 		})
 		acc.TempLikes = nil
 	}
 
-	if acc.Country != "" {
-		if !countryIndex.Exists(acc.Country) {
-			countryIndex.Update(acc.Country, treemap.NewWith(inverseIntComparator))
-		}
-		countryIndex.Get(acc.Country).(*treemap.Map).Put(acc.ID, &acc)
-	}
-	if acc.City != "" {
-		if !cityIndex.Exists(acc.City) {
-			cityIndex.Update(acc.City, treemap.NewWith(inverseIntComparator))
-		}
-		cityIndex.Get(acc.City).(*treemap.Map).Put(acc.ID, &acc)
-	}
-	if acc.birthYear > 0 {
-		if !birthYearIndex.Exists(acc.birthYear) {
-			birthYearIndex.Update(acc.birthYear, treemap.NewWith(inverseIntComparator))
-		}
-		birthYearIndex.Get(acc.birthYear).(*treemap.Map).Put(acc.ID, &acc)
-	}
-	if acc.Fname != "" {
-		if !fnameIndex.Exists(acc.Fname) {
-			fnameIndex.Update(acc.Fname, treemap.NewWith(inverseIntComparator))
-		}
-		fnameIndex.Get(acc.Fname).(*treemap.Map).Put(acc.ID, &acc)
-	}
-	if acc.Sname != "" {
-		if !snameIndex.Exists(acc.Sname) {
-			snameIndex.Update(acc.Sname, treemap.NewWith(inverseIntComparator))
-		}
-		snameIndex.Get(acc.Sname).(*treemap.Map).Put(acc.ID, &acc)
-	}
-	if acc.Sex != "" {
-		if !sexIndex.Exists(acc.Sex) {
-			sexIndex.Update(acc.Sex, treemap.NewWith(inverseIntComparator))
-		}
-		sexIndex.Get(acc.Sex).(*treemap.Map).Put(acc.ID, &acc)
-	}
-
+	indexAccount(&acc)
 	accountIndex.Put(acc.ID, &acc)
 }
 
@@ -359,55 +237,52 @@ func calculateSimilarityForUser(account *Account) *treemap.Map {
 		return nil
 	}
 	userSimilarityMap := treemap.NewWith(inverseFloat32Comparator)
-	var similarMap = map[*Account]float32{}
 
-	for likeId, tsList := range user1Likes {
-		ts1 := tsList.getTimestamp()
-		it := likeeIndex.Get(likeId).(*treemap.Map).Iterator()
+	var similarMap = map[int]float32{}
 
-		for it.Next() {
-			similarAcc := it.Value().(*Account)
-			ts2 := similarAcc.likes[likeId].getTimestamp()
+	for _, like := range user1Likes {
+		ts1 := int(like.Ts)
+		likeeIndex.Each(int(like.ID), func(liker LikerEntry) {
+			ts2 := int(liker.Ts)
 
 			if ts1 == ts2 {
-				similarMap[similarAcc] += 1
+				similarMap[int(liker.ID)] += 1
 			} else {
-				similarMap[similarAcc] += float32(1 / math.Abs(float64(ts1-ts2)))
+				similarMap[int(liker.ID)] += float32(1 / math.Abs(float64(ts1-ts2)))
 			}
-		}
+		})
 	}
 
-	for similarAcc, similarity := range similarMap {
-		userSimilarityMap.Put(similarity, similarAcc)
+	for similarId, similarity := range similarMap {
+		if similarAcc, ok := accountIndex.Get(similarId); ok {
+			userSimilarityMap.Put(similarity, similarAcc)
+		}
 	}
 
 	return userSimilarityMap
 }
 
 func updateLikes(data json.RawMessage) {
+	likesByLiker := make(map[int][]gjson.Result)
 	gjson.ParseBytes(data).ForEach(func(key, value gjson.Result) bool {
 		value.ForEach(func(key, value gjson.Result) bool {
-			like := value.Map()
-			likerId := int(like["liker"].Int())
-			likeeId := int(like["likee"].Int())
-
-			liker, _ := accountIndex.Get(likerId)
-
-			likerAcc := liker.(*Account)
-			if likerAcc.likes == nil {
-				likerAcc.likes = make(map[int]LikesList, 0)
-			}
-
-			likerAcc.AppendLike(likeeId, int(like["ts"].Int()))
-
-			if !likeeIndex.Exists(likeeId) {
-				likeeIndex.Update(likeeId, treemap.NewWith(inverseIntComparator))
-			}
-			likeeIndex.Get(likeeId).(*treemap.Map).Put(likerAcc.ID, likerAcc)
+			likerId := int(value.Get("liker").Int())
+			likesByLiker[likerId] = append(likesByLiker[likerId], value)
 
 			return true
 		})
 
 		return true
 	})
+
+	for likerId, likes := range likesByLiker {
+		liker, _ := accountIndex.Get(likerId)
+
+		likerAcc := liker.(*Account)
+		reindexAccount(likerAcc, []string{"likes"}, func() {
+			for _, like := range likes {
+				likerAcc.AppendLike(int(like.Get("likee").Int()), int(like.Get("ts").Int()))
+			}
+		})
+	}
 }
@@ -0,0 +1,713 @@
+package main
+
+import (
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+// Compressed bitmap over account ids. Ids are split into 2^16-wide chunks,
+// every chunk is stored either as a sorted array (sparse) or as a bitset (dense).
+
+const (
+	bitmapArrayMaxSize = 4096
+	// a bitset becomes an array again only well below the max size, so containers near it don't flip on every change
+	bitmapArrayMinSize = bitmapArrayMaxSize / 2
+	bitmapWords        = 1 << 16 / 64
+)
+
+type bitmapContainer struct {
+	array  []uint16
+	bitset []uint64
+	card   int
+}
+
+type Bitmap struct {
+	keys       []uint16
+	containers []*bitmapContainer
+}
+
+func NewBitmap() *Bitmap {
+	return &Bitmap{}
+}
+
+func NewBitmapOf(ids ...int) *Bitmap {
+	b := NewBitmap()
+	for _, id := range ids {
+		b.Add(id)
+	}
+
+	return b
+}
+
+func (b *Bitmap) containerIndex(key uint16) (int, bool) {
+	idx := sort.Search(len(b.keys), func(i int) bool { return b.keys[i] >= key })
+
+	return idx, idx < len(b.keys) && b.keys[idx] == key
+}
+
+func (b *Bitmap) Add(id int) {
+	key, low := uint16(id>>16), uint16(id)
+	idx, found := b.containerIndex(key)
+	if !found {
+		b.keys = append(b.keys, 0)
+		copy(b.keys[idx+1:], b.keys[idx:])
+		b.keys[idx] = key
+
+		b.containers = append(b.containers, nil)
+		copy(b.containers[idx+1:], b.containers[idx:])
+		b.containers[idx] = &bitmapContainer{}
+	}
+
+	b.containers[idx].add(low)
+}
+
+func (b *Bitmap) Remove(id int) {
+	key, low := uint16(id>>16), uint16(id)
+	idx, found := b.containerIndex(key)
+	if !found {
+		return
+	}
+
+	c := b.containers[idx]
+	c.remove(low)
+	if c.card == 0 {
+		b.keys = append(b.keys[:idx], b.keys[idx+1:]...)
+		b.containers = append(b.containers[:idx], b.containers[idx+1:]...)
+	}
+}
+
+func (b *Bitmap) Contains(id int) bool {
+	if b == nil {
+		return false
+	}
+	idx, found := b.containerIndex(uint16(id >> 16))
+	if !found {
+		return false
+	}
+
+	return b.containers[idx].contains(uint16(id))
+}
+
+func (b *Bitmap) Cardinality() int {
+	if b == nil {
+		return 0
+	}
+	total := 0
+	for _, c := range b.containers {
+		total += c.card
+	}
+
+	return total
+}
+
+// SizeInBytes is an estimation of memory used by containers
+func (b *Bitmap) SizeInBytes() int {
+	if b == nil {
+		return 0
+	}
+	total := 2*cap(b.keys) + 8*cap(b.containers)
+	for _, c := range b.containers {
+		total += 2*cap(c.array) + 8*cap(c.bitset) + 56
+	}
+
+	return total
+}
+
+func (b *Bitmap) IsEmpty() bool {
+	return b == nil || len(b.containers) == 0
+}
+
+func (b *Bitmap) Clone() *Bitmap {
+	result := &Bitmap{
+		keys:       make([]uint16, len(b.keys)),
+		containers: make([]*bitmapContainer, len(b.containers)),
+	}
+	copy(result.keys, b.keys)
+	for i, c := range b.containers {
+		result.containers[i] = c.clone()
+	}
+
+	return result
+}
+
+// And returns a new bitmap with ids present in both bitmaps
+func (b *Bitmap) And(other *Bitmap) *Bitmap {
+	result := NewBitmap()
+	i, j := 0, 0
+	for i < len(b.keys) && j < len(other.keys) {
+		switch {
+		case b.keys[i] < other.keys[j]:
+			i++
+		case b.keys[i] > other.keys[j]:
+			j++
+		default:
+			c := b.containers[i].and(other.containers[j])
+			if c.card > 0 {
+				result.keys = append(result.keys, b.keys[i])
+				result.containers = append(result.containers, c)
+			}
+			i++
+			j++
+		}
+	}
+
+	return result
+}
+
+// Or returns a new bitmap with ids present in any of bitmaps
+func (b *Bitmap) Or(other *Bitmap) *Bitmap {
+	result := NewBitmap()
+	i, j := 0, 0
+	for i < len(b.keys) || j < len(other.keys) {
+		switch {
+		case j == len(other.keys) || (i < len(b.keys) && b.keys[i] < other.keys[j]):
+			result.keys = append(result.keys, b.keys[i])
+			result.containers = append(result.containers, b.containers[i].clone())
+			i++
+		case i == len(b.keys) || b.keys[i] > other.keys[j]:
+			result.keys = append(result.keys, other.keys[j])
+			result.containers = append(result.containers, other.containers[j].clone())
+			j++
+		default:
+			result.keys = append(result.keys, b.keys[i])
+			result.containers = append(result.containers, b.containers[i].or(other.containers[j]))
+			i++
+			j++
+		}
+	}
+
+	return result
+}
+
+// OrWith adds ids of other to b in place, containers of other are cloned only when b lacks them
+func (b *Bitmap) OrWith(other *Bitmap) {
+	for j, key := range other.keys {
+		idx, found := b.containerIndex(key)
+		if found {
+			b.containers[idx].orWith(other.containers[j])
+			continue
+		}
+		b.keys = append(b.keys, 0)
+		copy(b.keys[idx+1:], b.keys[idx:])
+		b.keys[idx] = key
+
+		b.containers = append(b.containers, nil)
+		copy(b.containers[idx+1:], b.containers[idx:])
+		b.containers[idx] = other.containers[j].clone()
+	}
+}
+
+// AndNot returns a new bitmap with ids from b which are absent in other
+func (b *Bitmap) AndNot(other *Bitmap) *Bitmap {
+	result := NewBitmap()
+	j := 0
+	for i, key := range b.keys {
+		for j < len(other.keys) && other.keys[j] < key {
+			j++
+		}
+		var c *bitmapContainer
+		if j < len(other.keys) && other.keys[j] == key {
+			c = b.containers[i].andNot(other.containers[j])
+		} else {
+			c = b.containers[i].clone()
+		}
+		if c.card > 0 {
+			result.keys = append(result.keys, key)
+			result.containers = append(result.containers, c)
+		}
+	}
+
+	return result
+}
+
+// Each iterates ids in descending order (the same order as inverseIntComparator indexes)
+func (b *Bitmap) Each(f func(id int) bool) {
+	for i := len(b.keys) - 1; i >= 0; i-- {
+		high := int(b.keys[i]) << 16
+		if !b.containers[i].eachReverse(func(low uint16) bool {
+			return f(high | int(low))
+		}) {
+			return
+		}
+	}
+}
+
+// ReverseIterator returns a pull-style iterator over ids in descending order
+func (b *Bitmap) ReverseIterator() *BitmapIterator {
+	return &BitmapIterator{bitmap: b, containerIdx: len(b.keys), pos: -1}
+}
+
+// ReverseIteratorBefore returns an iterator over ids lower than before in descending order
+func (b *Bitmap) ReverseIteratorBefore(before int) *BitmapIterator {
+	high, low := uint16(before>>16), uint16(before&0xFFFF)
+	i := sort.Search(len(b.keys), func(i int) bool { return b.keys[i] > high })
+	it := &BitmapIterator{bitmap: b, containerIdx: i, pos: -1}
+	if i == 0 || b.keys[i-1] != high {
+		return it
+	}
+
+	it.containerIdx = i - 1
+	c := b.containers[i-1]
+	if c.bitset == nil {
+		it.pos = sort.Search(len(c.array), func(j int) bool { return c.array[j] >= low }) - 1
+	} else {
+		word := int(low >> 6)
+		it.word = c.bitset[word] & (1<<(low&63) - 1)
+		it.pos = word - 1
+	}
+
+	return it
+}
+
+func (b *Bitmap) ToSlice() []int {
+	result := make([]int, 0, b.Cardinality())
+	b.Each(func(id int) bool {
+		result = append(result, id)
+		return true
+	})
+
+	return result
+}
+
+type BitmapIterator struct {
+	bitmap       *Bitmap
+	containerIdx int
+	pos          int // position in array or word index in bitset
+	word         uint64
+	value        int
+}
+
+func (it *BitmapIterator) Next() bool {
+	for {
+		if it.containerIdx < len(it.bitmap.keys) {
+			c := it.bitmap.containers[it.containerIdx]
+			high := int(it.bitmap.keys[it.containerIdx]) << 16
+			if c.bitset == nil {
+				if it.pos >= 0 {
+					it.value = high | int(c.array[it.pos])
+					it.pos--
+					return true
+				}
+			} else {
+				for it.word == 0 && it.pos >= 0 {
+					it.word = c.bitset[it.pos]
+					it.pos--
+				}
+				if it.word != 0 {
+					bit := 63 - bits.LeadingZeros64(it.word)
+					it.word &^= 1 << uint(bit)
+					it.value = high | ((it.pos+1)<<6 + bit)
+					return true
+				}
+			}
+		}
+
+		if it.containerIdx == 0 {
+			return false
+		}
+		it.containerIdx--
+		c := it.bitmap.containers[it.containerIdx]
+		it.word = 0
+		if c.bitset == nil {
+			it.pos = len(c.array) - 1
+		} else {
+			it.pos = len(c.bitset) - 1
+		}
+	}
+}
+
+func (it *BitmapIterator) Value() int {
+	return it.value
+}
+
+func (c *bitmapContainer) contains(x uint16) bool {
+	if c.bitset != nil {
+		return c.bitset[x>>6]&(1<<(x&63)) != 0
+	}
+	idx := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= x })
+
+	return idx < len(c.array) && c.array[idx] == x
+}
+
+func (c *bitmapContainer) add(x uint16) {
+	if c.bitset != nil {
+		mask := uint64(1) << (x & 63)
+		if c.bitset[x>>6]&mask == 0 {
+			c.bitset[x>>6] |= mask
+			c.card++
+		}
+		return
+	}
+
+	idx := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= x })
+	if idx < len(c.array) && c.array[idx] == x {
+		return
+	}
+	c.array = append(c.array, 0)
+	copy(c.array[idx+1:], c.array[idx:])
+	c.array[idx] = x
+	c.card++
+
+	if c.card > bitmapArrayMaxSize {
+		c.toBitset()
+	}
+}
+
+func (c *bitmapContainer) remove(x uint16) {
+	if c.bitset != nil {
+		mask := uint64(1) << (x & 63)
+		if c.bitset[x>>6]&mask != 0 {
+			c.bitset[x>>6] &^= mask
+			c.card--
+		}
+		if c.card < bitmapArrayMinSize {
+			c.toArray()
+		}
+		return
+	}
+
+	idx := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= x })
+	if idx < len(c.array) && c.array[idx] == x {
+		c.array = append(c.array[:idx], c.array[idx+1:]...)
+		c.card--
+	}
+}
+
+func (c *bitmapContainer) toBitset() {
+	c.bitset = make([]uint64, bitmapWords)
+	for _, x := range c.array {
+		c.bitset[x>>6] |= 1 << (x & 63)
+	}
+	c.array = nil
+}
+
+func (c *bitmapContainer) toArray() {
+	c.array = make([]uint16, 0, c.card)
+	for i, word := range c.bitset {
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			c.array = append(c.array, uint16(i<<6+bit))
+			word &= word - 1
+		}
+	}
+	c.bitset = nil
+}
+
+func (c *bitmapContainer) words() []uint64 {
+	if c.bitset != nil {
+		return c.bitset
+	}
+	result := make([]uint64, bitmapWords)
+	for _, x := range c.array {
+		result[x>>6] |= 1 << (x & 63)
+	}
+
+	return result
+}
+
+func (c *bitmapContainer) clone() *bitmapContainer {
+	result := &bitmapContainer{card: c.card}
+	if c.bitset != nil {
+		result.bitset = make([]uint64, bitmapWords)
+		copy(result.bitset, c.bitset)
+	} else {
+		result.array = make([]uint16, len(c.array))
+		copy(result.array, c.array)
+	}
+
+	return result
+}
+
+func newContainerFromWords(words []uint64) *bitmapContainer {
+	c := &bitmapContainer{bitset: words}
+	for _, word := range words {
+		c.card += bits.OnesCount64(word)
+	}
+	if c.card <= bitmapArrayMaxSize {
+		c.toArray()
+	}
+
+	return c
+}
+
+func (c *bitmapContainer) and(other *bitmapContainer) *bitmapContainer {
+	if c.bitset == nil || other.bitset == nil {
+		// at least one side is sparse: probe the smaller array
+		small, large := c, other
+		if small.bitset != nil || (large.bitset == nil && len(large.array) < len(small.array)) {
+			small, large = large, small
+		}
+		result := &bitmapContainer{array: make([]uint16, 0, len(small.array))}
+		for _, x := range small.array {
+			if large.contains(x) {
+				result.array = append(result.array, x)
+			}
+		}
+		result.card = len(result.array)
+		return result
+	}
+
+	words := make([]uint64, bitmapWords)
+	for i := range words {
+		words[i] = c.bitset[i] & other.bitset[i]
+	}
+
+	return newContainerFromWords(words)
+}
+
+func (c *bitmapContainer) or(other *bitmapContainer) *bitmapContainer {
+	words := make([]uint64, bitmapWords)
+	copy(words, c.words())
+	if other.bitset != nil {
+		for i, word := range other.bitset {
+			words[i] |= word
+		}
+	} else {
+		for _, x := range other.array {
+			words[x>>6] |= 1 << (x & 63)
+		}
+	}
+
+	return newContainerFromWords(words)
+}
+
+// orWith merges other into c, sorted arrays are merged from the end so c isn't reallocated
+func (c *bitmapContainer) orWith(other *bitmapContainer) {
+	if c.bitset == nil && (other.bitset != nil || c.card+other.card > bitmapArrayMaxSize) {
+		c.toBitset()
+	}
+	if c.bitset != nil {
+		if other.bitset == nil {
+			for _, x := range other.array {
+				c.add(x)
+			}
+			return
+		}
+		for i, word := range other.bitset {
+			c.card += bits.OnesCount64(word &^ c.bitset[i])
+			c.bitset[i] |= word
+		}
+		return
+	}
+
+	n := len(c.array)
+	c.array = append(c.array, other.array...)
+	i, j, k := n-1, len(other.array)-1, len(c.array)-1
+	for j >= 0 {
+		switch {
+		case i >= 0 && c.array[i] > other.array[j]:
+			c.array[k] = c.array[i]
+			i--
+		case i >= 0 && c.array[i] == other.array[j]:
+			c.array[k] = c.array[i]
+			i--
+			j--
+		default:
+			c.array[k] = other.array[j]
+			j--
+		}
+		k--
+	}
+	// duplicates leave a gap after the untouched head of c
+	if k > i {
+		copy(c.array[i+1:], c.array[k+1:])
+		c.array = c.array[:len(c.array)-(k-i)]
+	}
+	c.card = len(c.array)
+}
+
+func (c *bitmapContainer) andNot(other *bitmapContainer) *bitmapContainer {
+	if c.bitset == nil {
+		result := &bitmapContainer{array: make([]uint16, 0, len(c.array))}
+		for _, x := range c.array {
+			if !other.contains(x) {
+				result.array = append(result.array, x)
+			}
+		}
+		result.card = len(result.array)
+		return result
+	}
+
+	words := make([]uint64, bitmapWords)
+	copy(words, c.bitset)
+	if other.bitset != nil {
+		for i, word := range other.bitset {
+			words[i] &^= word
+		}
+	} else {
+		for _, x := range other.array {
+			words[x>>6] &^= 1 << (x & 63)
+		}
+	}
+
+	return newContainerFromWords(words)
+}
+
+func (c *bitmapContainer) eachReverse(f func(x uint16) bool) bool {
+	if c.bitset == nil {
+		for i := len(c.array) - 1; i >= 0; i-- {
+			if !f(c.array[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i := len(c.bitset) - 1; i >= 0; i-- {
+		word := c.bitset[i]
+		for word != 0 {
+			bit := 63 - bits.LeadingZeros64(word)
+			if !f(uint16(i<<6 + bit)) {
+				return false
+			}
+			word &^= 1 << uint(bit)
+		}
+	}
+
+	return true
+}
+
+// BitmapIndex keeps one bitmap per key, e.g. sex -> ids
+type BitmapIndex struct {
+	v   map[interface{}]*Bitmap
+	mux sync.RWMutex
+}
+
+func NewBitmapIndex() *BitmapIndex {
+	return &BitmapIndex{v: map[interface{}]*Bitmap{}}
+}
+
+func (idx *BitmapIndex) Add(key interface{}, id int) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	bitmap, ok := idx.v[key]
+	if !ok {
+		bitmap = NewBitmap()
+		idx.v[key] = bitmap
+	}
+	bitmap.Add(id)
+}
+
+func (idx *BitmapIndex) Remove(key interface{}, id int) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	if bitmap, ok := idx.v[key]; ok {
+		bitmap.Remove(id)
+	}
+}
+
+// Get returns the bitmap itself, it is a read-only view which is valid under the store read lock.
+// And / Or / AndNot don't modify operands, callers which mutate the result must Clone it.
+func (idx *BitmapIndex) Get(key interface{}) *Bitmap {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	if bitmap, ok := idx.v[key]; ok {
+		return bitmap
+	}
+
+	return NewBitmap()
+}
+
+func (idx *BitmapIndex) Replace(key interface{}, bitmap *Bitmap) {
+	idx.mux.Lock()
+
+	idx.v[key] = bitmap
+
+	idx.mux.Unlock()
+}
+
+func (idx *BitmapIndex) Contains(key interface{}, id int) bool {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	return idx.v[key].Contains(id)
+}
+
+// Each calls f for every id of every key under the lock, f must not modify the index
+func (idx *BitmapIndex) Each(f func(key interface{}, id int)) {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	for key, bitmap := range idx.v {
+		bitmap.Each(func(id int) bool {
+			f(key, id)
+			return true
+		})
+	}
+}
+
+func (idx *BitmapIndex) Size() int {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	return len(idx.v)
+}
+
+func (idx *BitmapIndex) SizeInBytes() int {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	total := 0
+	for _, bitmap := range idx.v {
+		total += bitmap.SizeInBytes()
+	}
+
+	return total
+}
+
+func (idx *BitmapIndex) Cardinality(key interface{}) int {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	return idx.v[key].Cardinality()
+}
+
+// BitmapQuery accumulates AND / AND NOT operands and resolves them at once
+type BitmapQuery struct {
+	and    []*Bitmap
+	andNot []*Bitmap
+}
+
+func (q *BitmapQuery) And(b *Bitmap) {
+	q.and = append(q.and, b)
+}
+
+func (q *BitmapQuery) AndNot(b *Bitmap) {
+	q.andNot = append(q.andNot, b)
+}
+
+func (q *BitmapQuery) IsEmpty() bool {
+	return len(q.and) == 0 && len(q.andNot) == 0
+}
+
+// Resolve intersects operands starting from the smallest one, all is used only when there are no AND operands
+func (q *BitmapQuery) Resolve(all func() *Bitmap) *Bitmap {
+	var result *Bitmap
+	if len(q.and) == 0 {
+		result = all()
+	} else {
+		sort.Slice(q.and, func(i, j int) bool {
+			return q.and[i].Cardinality() < q.and[j].Cardinality()
+		})
+		result = q.and[0]
+		for _, b := range q.and[1:] {
+			if result.IsEmpty() {
+				return result
+			}
+			result = result.And(b)
+		}
+	}
+
+	for _, b := range q.andNot {
+		if result.IsEmpty() {
+			return result
+		}
+		result = result.AndNot(b)
+	}
+
+	return result
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBitmapOperations(t *testing.T) {
+	a := NewBitmapOf(1, 5, 70000, 70001, 1<<20)
+	b := NewBitmapOf(5, 70001, 9)
+
+	if !reflect.DeepEqual(a.And(b).ToSlice(), []int{70001, 5}) {
+		t.Error("And:", a.And(b).ToSlice())
+	}
+	if !reflect.DeepEqual(a.Or(b).ToSlice(), []int{1 << 20, 70001, 70000, 9, 5, 1}) {
+		t.Error("Or:", a.Or(b).ToSlice())
+	}
+	if !reflect.DeepEqual(a.AndNot(b).ToSlice(), []int{1 << 20, 70000, 1}) {
+		t.Error("AndNot:", a.AndNot(b).ToSlice())
+	}
+
+	a.Remove(70000)
+	if a.Contains(70000) || a.Cardinality() != 4 {
+		t.Error("Remove:", a.ToSlice())
+	}
+}
+
+func TestBitmapDenseContainer(t *testing.T) {
+	dense := NewBitmap()
+	sparse := NewBitmap()
+	for i := 0; i < 10000; i++ {
+		dense.Add(i * 2)
+		if i%3 == 0 {
+			sparse.Add(i)
+		}
+	}
+
+	if dense.Cardinality() != 10000 || dense.containers[0].bitset == nil {
+		t.Fatal("expected bitset container with 10000 ids, got", dense.Cardinality())
+	}
+
+	and := dense.And(sparse)
+	for _, id := range and.ToSlice() {
+		if id%6 != 0 {
+			t.Fatal("unexpected id in And:", id)
+		}
+	}
+	if and.Cardinality() != 1667 {
+		t.Error("And cardinality:", and.Cardinality())
+	}
+	if dense.AndNot(sparse).Cardinality() != 10000-1667 {
+		t.Error("AndNot cardinality:", dense.AndNot(sparse).Cardinality())
+	}
+	if dense.Or(sparse).Cardinality() != 10000+3334-1667 {
+		t.Error("Or cardinality:", dense.Or(sparse).Cardinality())
+	}
+
+	var iterated []int
+	it := dense.ReverseIterator()
+	for it.Next() {
+		iterated = append(iterated, it.Value())
+	}
+	if !reflect.DeepEqual(iterated, dense.ToSlice()) || iterated[0] != 19998 {
+		t.Error("ReverseIterator differs from Each")
+	}
+}
+
+func TestBitmapOrWith(t *testing.T) {
+	a := NewBitmapOf(1, 5, 70000)
+	a.OrWith(NewBitmapOf(0, 5, 9, 70001, 1<<20))
+	if !reflect.DeepEqual(a.ToSlice(), []int{1 << 20, 70001, 70000, 9, 5, 1, 0}) || a.Cardinality() != 7 {
+		t.Error("OrWith:", a.ToSlice())
+	}
+
+	dense, sparse := NewBitmap(), NewBitmap()
+	for i := 0; i < 10000; i++ {
+		dense.Add(i * 2)
+		if i%3 == 0 {
+			sparse.Add(i)
+		}
+	}
+	merged := sparse.Clone()
+	merged.OrWith(dense)
+	if merged.Cardinality() != 10000+3334-1667 || merged.containers[0].bitset == nil {
+		t.Error("OrWith dense cardinality:", merged.Cardinality())
+	}
+	if !reflect.DeepEqual(merged.ToSlice(), dense.Or(sparse).ToSlice()) {
+		t.Error("OrWith differs from Or")
+	}
+	if sparse.Cardinality() != 3334 {
+		t.Error("OrWith modified the source:", sparse.Cardinality())
+	}
+}
+
+func TestBitmapReverseIteratorBefore(t *testing.T) {
+	dense := NewBitmap()
+	for i := 0; i < 10000; i++ {
+		dense.Add(i * 2)
+	}
+	bitmaps := []*Bitmap{NewBitmapOf(1, 5, 70000, 70001, 1<<20), dense}
+
+	for _, bitmap := range bitmaps {
+		all := bitmap.ToSlice()
+		for _, before := range []int{0, 1, 5, 6, 128, 129, 70001, 100000, 1 << 20, 1<<20 + 1} {
+			var expected, iterated []int
+			for _, id := range all {
+				if id < before {
+					expected = append(expected, id)
+				}
+			}
+			it := bitmap.ReverseIteratorBefore(before)
+			for it.Next() {
+				iterated = append(iterated, it.Value())
+			}
+			if !reflect.DeepEqual(iterated, expected) {
+				t.Errorf("before %d: expected %d ids, got %d", before, len(expected), len(iterated))
+			}
+		}
+	}
+}
+
+func TestBitmapQuery(t *testing.T) {
+	all := NewBitmapOf(1, 2, 3, 4, 5, 6)
+
+	var query BitmapQuery
+	query.AndNot(NewBitmapOf(2, 4))
+	result := query.Resolve(func() *Bitmap { return all })
+	if !reflect.DeepEqual(result.ToSlice(), []int{6, 5, 3, 1}) {
+		t.Error("AndNot from all:", result.ToSlice())
+	}
+
+	query.And(NewBitmapOf(1, 2, 3))
+	result = query.Resolve(func() *Bitmap { return all })
+	if !reflect.DeepEqual(result.ToSlice(), []int{3, 1}) {
+		t.Error("And + AndNot:", result.ToSlice())
+	}
+}
+
+func TestBitmapIndexGetView(t *testing.T) {
+	idx := NewBitmapIndex()
+	for id := 0; id < 10000; id++ {
+		idx.Add("all", id)
+	}
+
+	if allocs := testing.AllocsPerRun(10, func() { idx.Get("all") }); allocs != 0 {
+		t.Error("Get copies the bitmap:", allocs)
+	}
+
+	// combining views doesn't change the index
+	var query BitmapQuery
+	query.And(idx.Get("all"))
+	query.AndNot(NewBitmapOf(1, 2, 3))
+	if result := query.Resolve(nil); result.Cardinality() != 9997 || idx.Cardinality("all") != 10000 {
+		t.Error("index was modified by the query:", result.Cardinality(), idx.Cardinality("all"))
+	}
+	query = BitmapQuery{}
+	query.And(idx.Get("all"))
+	query.And(NewBitmapOf(5, 20000))
+	if result := query.Resolve(nil); !reflect.DeepEqual(result.ToSlice(), []int{5}) || idx.Cardinality("all") != 10000 {
+		t.Error("index was modified by the query:", result.ToSlice(), idx.Cardinality("all"))
+	}
+}
+
+func TestBitmapContainerHysteresis(t *testing.T) {
+	b := NewBitmap()
+	for id := 0; id <= bitmapArrayMaxSize; id++ {
+		b.Add(id)
+	}
+	if b.containers[0].bitset == nil {
+		t.Fatal("expected bitset container above the max size")
+	}
+
+	// removing and adding around the max size keeps the bitset
+	for i := 0; i < 10; i++ {
+		b.Remove(bitmapArrayMaxSize)
+		if b.containers[0].bitset == nil {
+			t.Fatal("bitset was converted at the max size")
+		}
+		b.Add(bitmapArrayMaxSize)
+	}
+
+	for id := bitmapArrayMaxSize; id >= bitmapArrayMinSize-1; id-- {
+		b.Remove(id)
+	}
+	if b.containers[0].bitset != nil || b.Cardinality() != bitmapArrayMinSize-1 {
+		t.Error("bitset wasn't converted below the min size:", b.Cardinality())
+	}
+
+	// a bitset below the max size is merged into an array container
+	sparse := NewBitmap()
+	for id := 0; id <= bitmapArrayMaxSize; id++ {
+		sparse.Add(id)
+	}
+	for id := 3000; id <= bitmapArrayMaxSize; id++ {
+		sparse.Remove(id)
+	}
+	merged := NewBitmapOf(1, 60000)
+	merged.OrWith(sparse)
+	if merged.Cardinality() != 3001 || !merged.Contains(60000) || !merged.Contains(2999) || merged.Contains(3000) {
+		t.Error("OrWith of a sparse bitset:", merged.Cardinality())
+	}
+}
@@ -148,6 +148,10 @@ var compTestData = []compTest{
 }
 
 func TestComparator(t *testing.T) {
+	if accountIndex.Empty() {
+		t.Skip("data is not loaded")
+	}
+
 	for _, compTest := range compTestData {
 		expectedData := compTest.expectedIDs
 
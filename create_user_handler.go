@@ -70,26 +70,31 @@ func createUserHandler(ctx *fasthttp.RequestCtx) {
 		}
 	}
 
-	// unique id
-	if _, found := accountIndex.Get(account.ID); found {
+	// unique values are reserved until the account is created
+	var reservations Reservations
+
+	if !reservations.Reserve(uniqueIds, account.ID) {
 		ctx.Error(`{"err":"id_already_exists"}`, 400)
 		return
 	}
 
-	// unique email
-	if emailIndex.Exists(account.Email) {
+	if !reservations.Reserve(uniqueEmails, account.Email) {
+		reservations.Release()
 		ctx.Error(`{"err":"email_already_exists"}`, 400)
 		return
 	}
 
-	// unique phone
-	if phoneIndex.Exists(account.Phone) {
+	if account.Phone != "" && !reservations.Reserve(uniquePhones, account.Phone) {
+		reservations.Release()
 		ctx.Error(`{"err":"phone_already_exists"}`, 400)
 		return
 	}
 
-	// creating in goroutine
-	go NewAccount(account)
+	applyWrite(ctx, func() {
+		reservations.Commit(func() {
+			NewAccount(account)
+		})
+	})
 
 	// unique
 	createdSuccessResponse(ctx)
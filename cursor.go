@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const cursorParam = "cursor"
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// FilterCursor is a position of the descending id scan or of the sort order when sort is requested,
+// clients get it as an opaque token
+type FilterCursor struct {
+	ID    int
+	Key   string
+	keyed bool
+}
+
+func parseCursor(value []byte) (FilterCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(string(value))
+	if err != nil {
+		return FilterCursor{}, errInvalidCursor
+	}
+	// the sort key follows the id, so it could contain any characters
+	cursor := FilterCursor{}
+	idPart := string(decoded)
+	if comma := strings.IndexByte(idPart, ','); comma >= 0 {
+		cursor.Key, cursor.keyed = idPart[comma+1:], true
+		idPart = idPart[:comma]
+	}
+	if cursor.ID, err = strconv.Atoi(idPart); err != nil || cursor.ID <= 0 {
+		return FilterCursor{}, errInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+func (c FilterCursor) String() string {
+	token := strconv.AppendInt(nil, int64(c.ID), 10)
+	if c.keyed {
+		token = append(append(token, ','), c.Key...)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// appendCursor adds the cursor next to the accounts array of the response built by prepareResponseBytes
+func appendCursor(response []byte, cursor FilterCursor) []byte {
+	response = append(response[:len(response)-1], `,"cursor":"`...)
+	response = append(response, cursor.String()...)
+
+	return append(response, `"}`...)
+}
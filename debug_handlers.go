@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+func registerDebugHandlers() {
+	http.HandleFunc("/debug/indexes", debugIndexesHandler)
+	http.HandleFunc("/debug/indexes/check", debugIndexesCheckHandler)
+	http.HandleFunc("/debug/stats", debugStatsHandler)
+}
+
+// debugIndexesHandler lists registered secondary indexes
+func debugIndexesHandler(w http.ResponseWriter, r *http.Request) {
+	type indexInfo struct {
+		Name string `json:"name"`
+		Kind string `json:"kind"`
+		Keys int    `json:"keys"`
+	}
+
+	result := make([]indexInfo, 0, len(secondaryIndexes))
+	readLocked(func() {
+		for _, index := range secondaryIndexes {
+			result = append(result, indexInfo{index.name, index.kind, index.store.keysCount()})
+		}
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"indexes": result})
+}
+
+// debugIndexesCheckHandler diffs indexes against accounts, ?repair=1 fixes found mismatches
+func debugIndexesCheckHandler(w http.ResponseWriter, r *http.Request) {
+	results := checkIndexes(r.URL.Query().Get("repair") == "1")
+
+	consistent := true
+	for _, result := range results {
+		consistent = consistent && result.IsConsistent()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"consistent": consistent, "indexes": results})
+}
+
+// debugStatsHandler reports cardinality and estimated memory of every index
+func debugStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(collectStats())
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const explainParam = "explain"
+
+// Explain describes how a query was executed, it is returned instead of the result
+// when explain=1 is passed in debug mode
+type Explain struct {
+	Query         string              `json:"query"`
+	Strategy      string              `json:"strategy,omitempty"`
+	Index         string              `json:"index"`
+	Candidates    int                 `json:"candidates"`
+	EstimatedCost float64             `json:"estimated_cost,omitempty"`
+	Predicates    []*PredicateExplain `json:"predicates"`
+	Scanned       int                 `json:"scanned"`
+	Returned      int                 `json:"returned"`
+	PlanningUs    int64               `json:"planning_us"`
+	ExecutionUs   int64               `json:"execution_us"`
+
+	started  time.Time
+	planned  time.Time
+	residual []*PredicateExplain
+}
+
+type PredicateExplain struct {
+	Name        string  `json:"name"`
+	Indexed     bool    `json:"indexed,omitempty"`
+	Selectivity float64 `json:"selectivity,omitempty"`
+	Evaluated   int     `json:"evaluated"`
+	Passed      int     `json:"passed"`
+}
+
+func isExplainParam(key []byte) bool {
+	return isDebugMode != "" && string(key) == explainParam
+}
+
+// newExplain returns nil unless explain is requested, handlers skip tracing for nil
+func newExplain(ctx *fasthttp.RequestCtx, query string) *Explain {
+	if isDebugMode == "" || string(ctx.QueryArgs().Peek(explainParam)) != "1" {
+		return nil
+	}
+	now := time.Now()
+
+	return &Explain{Query: query, Predicates: []*PredicateExplain{}, started: now, planned: now}
+}
+
+// Plan records the plan chosen by the planner, residual predicates are traced by the executor
+func (e *Explain) Plan(plan *QueryPlan) {
+	e.Strategy = plan.strategy
+	e.EstimatedCost = plan.cost
+	for _, predicate := range plan.indexes {
+		e.Predicates = append(e.Predicates, &PredicateExplain{Name: predicate.name, Indexed: true, Selectivity: predicate.selectivity})
+	}
+	for _, predicate := range plan.residual {
+		residual := &PredicateExplain{Name: predicate.name, Selectivity: predicate.selectivity}
+		e.residual = append(e.residual, residual)
+		e.Predicates = append(e.Predicates, residual)
+	}
+	e.planned = time.Now()
+}
+
+// Source records the index which candidates are read from
+func (e *Explain) Source(index *NamedIndex) {
+	e.Index = string(index.name)
+	e.Candidates = index.Size()
+}
+
+// Predicate adds a predicate which is checked by a handler without the planner
+func (e *Explain) Predicate(name string) *PredicateExplain {
+	predicate := &PredicateExplain{Name: name}
+	e.Predicates = append(e.Predicates, predicate)
+
+	return predicate
+}
+
+func (p *PredicateExplain) Evaluate(passed bool) bool {
+	p.Evaluated++
+	if passed {
+		p.Passed++
+	}
+
+	return passed
+}
+
+// emptyExplainedResponse is used when some predicate has no accounts at all, so nothing is scanned
+func emptyExplainedResponse(ctx *fasthttp.RequestCtx, e *Explain, emptyResponse func(ctx *fasthttp.RequestCtx)) {
+	if e == nil {
+		emptyResponse(ctx)
+		return
+	}
+	e.Strategy = planEmpty
+	e.planned = time.Now()
+	explainResponse(ctx, e)
+}
+
+func explainResponse(ctx *fasthttp.RequestCtx, e *Explain) {
+	finished := time.Now()
+	e.PlanningUs = e.planned.Sub(e.started).Microseconds()
+	e.ExecutionUs = finished.Sub(e.planned).Microseconds()
+
+	body, _ := json.Marshal(e)
+	ctx.Success("application/json", body)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	firstId, cleanup := putFilterTestAccounts()
+	defer cleanup()
+
+	query := "/accounts/filter/?interests_contains=filter_b&fname_eq=Фильтр&limit=10&explain=1"
+	ctx := testRequest("GET", query, "")
+	requestHandler(ctx)
+	if ctx.Response.StatusCode() != 400 {
+		t.Error("explain is accepted without debug mode")
+	}
+
+	defer func(mode string) {
+		isDebugMode = mode
+	}(isDebugMode)
+	isDebugMode = "1"
+
+	for _, query := range []string{
+		query,
+		"/accounts/group/?keys=sex&order=1&limit=10&interests=filter_b&fname=Фильтр&explain=1",
+		fmt.Sprintf("/accounts/%d/recommend/?limit=10&explain=1", firstId),
+	} {
+		ctx = testRequest("GET", query, "")
+		requestHandler(ctx)
+
+		var explain Explain
+		if err := json.Unmarshal(ctx.Response.Body(), &explain); err != nil {
+			t.Fatalf("%s: invalid explain %s", query, ctx.Response.Body())
+		}
+		if explain.Index == "" || len(explain.Predicates) == 0 || explain.Scanned < explain.Returned {
+			t.Errorf("%s: incomplete explain %+v", query, explain)
+		}
+	}
+
+	ctx = testRequest("GET", query, "")
+	requestHandler(ctx)
+	var explain Explain
+	json.Unmarshal(ctx.Response.Body(), &explain)
+	if explain.Returned != 2 || explain.Candidates < explain.Returned {
+		t.Errorf("unexpected filter explain %s", ctx.Response.Body())
+	}
+}
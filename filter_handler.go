@@ -2,28 +2,34 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/emirpasic/gods/maps/treemap"
 	"github.com/valyala/fasthttp"
 )
 
 var allowedParams = map[string]int{
-	"query_id": 1, "limit": 1,
+	"query_id": 1, "limit": 1, "cursor": 1, "sort": 1, "fields": 1, "count": 1,
 	"sex_eq":       1,
 	"email_domain": 1, "email_lt": 1, "email_gt": 1,
 	"status_eq": 1, "status_neq": 1,
-	"fname_eq": 1, "fname_any": 1, "fname_null": 1,
-	"sname_eq": 1, "sname_starts": 1, "sname_null": 1,
+	"fname_eq": 1, "fname_any": 1, "fname_null": 1, "fname_like": 1, "fname_starts": 1,
+	"sname_eq": 1, "sname_starts": 1, "sname_null": 1, "sname_like": 1,
 	"phone_code": 1, "phone_null": 1,
 	"country_eq": 1, "country_null": 1,
-	"city_eq": 1, "city_any": 1, "city_null": 1,
+	"city_eq": 1, "city_any": 1, "city_null": 1, "city_ieq": 1,
 	"birth_year": 1, "birth_lt": 1, "birth_gt": 1,
+	"joined_year": 1, "joined_lt": 1, "joined_gt": 1,
+	"age_lt": 1, "age_gt": 1,
 	"interests_contains": 1, "interests_any": 1,
-	"likes_contains": 1,
-	"premium_now":    1, "premium_null": 1,
+	"likes_contains": 1, "likes_any": 1, "likes_count_lt": 1, "likes_count_gt": 1,
+	"liked_by_contains": 1, "liked_by_any": 1, "liked_count_lt": 1, "liked_count_gt": 1,
+	"premium_now": 1, "premium_null": 1,
+	"premium_finish_lt": 1, "premium_finish_gt": 1,
 }
 
 var bytesPool = &sync.Pool{
@@ -32,22 +38,19 @@ var bytesPool = &sync.Pool{
 	},
 }
 
-var namedIndexPool = &sync.Pool{
-	New: func() interface{} {
-		return new(NamedIndex)
-	},
+func filterHandler(ctx *fasthttp.RequestCtx) {
+	filter(ctx, false)
 }
 
-var treemapPool = &sync.Pool{
-	New: func() interface{} {
-		return treemap.NewWithIntComparator()
-	},
+// filterCountHandler is /accounts/filter/count/, it is the same as count=1
+func filterCountHandler(ctx *fasthttp.RequestCtx) {
+	filter(ctx, true)
 }
 
-func filterHandler(ctx *fasthttp.RequestCtx) {
+func filter(ctx *fasthttp.RequestCtx, count bool) {
 	validQueryArgs := true
 	ctx.QueryArgs().VisitAll(func(key, value []byte) {
-		if _, ok := allowedParams[string(key)]; !ok {
+		if _, ok := allowedParams[string(key)]; !ok && !isExplainParam(key) {
 			validQueryArgs = false
 			return
 		}
@@ -57,714 +60,746 @@ func filterHandler(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	// ignore interests, likes
-	responseProperties := make([]string, 0, 128)
-	responseProperties = append(responseProperties, "id", "email")
-
-	var limit int
-	var err error
-	if limit, err = strconv.Atoi(string(ctx.QueryArgs().Peek("limit"))); err != nil {
+	if count {
+		ctx.QueryArgs().Set(countParam, "1")
+	}
+	options, err := parseFilterOptions(ctx.QueryArgs())
+	if err != nil {
 		ctx.Error("{}", 400)
 		return
 	}
-	if limit <= 0 {
+
+	explain := newExplain(ctx, "filter")
+
+	predicates, responseProperties, err := filterPredicates(ctx.QueryArgs())
+	if err == errNoAccounts {
+		emptyExplainedResponse(ctx, explain, options.emptyResponse)
+		return
+	} else if err != nil {
 		ctx.Error("{}", 400)
 		return
 	}
-	// Limit is required
 
-	sexEqF := ctx.QueryArgs().Peek("sex_eq")
+	findFiltered(ctx, predicates, responseProperties, options, explain)
+}
+
+const countParam = "count"
+
+// FilterOptions are parameters of filter which don't select accounts
+type FilterOptions struct {
+	limit  int
+	order  *FilterSort
+	fields []string
+	cursor FilterCursor
+	after  *sortedAccount
+	// count returns the amount of matching accounts instead of them
+	count bool
+}
+
+func parseFilterOptions(args *fasthttp.Args) (*FilterOptions, error) {
+	options := &FilterOptions{}
+	var err error
+	switch string(args.Peek(countParam)) {
+	case "1":
+		options.count = true
+	case "", "0":
+	default:
+		return nil, errInvalidFilter
+	}
+
+	// Limit is required unless accounts are only counted
+	if options.limit, err = strconv.Atoi(string(args.Peek("limit"))); (err != nil || options.limit <= 0) && !options.count {
+		return nil, errInvalidFilter
+	}
+	if options.count {
+		// the whole set is counted, pages and order don't change it
+		return options, nil
+	}
+
+	if args.Has(sortParam) {
+		if options.order, err = parseFilterSort(args.Peek(sortParam)); err != nil {
+			return nil, err
+		}
+	}
+
+	if args.Has(fieldsParam) {
+		if options.fields, err = parseResponseFields(args.Peek(fieldsParam)); err != nil {
+			return nil, err
+		}
+	}
+
+	if args.Has(cursorParam) {
+		if options.cursor, err = parseCursor(args.Peek(cursorParam)); err != nil {
+			return nil, err
+		}
+		if options.order != nil {
+			if options.after, err = options.order.position(options.cursor); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return options, nil
+}
+
+var (
+	errInvalidFilter = errors.New("invalid filter")
+	// errNoAccounts is returned when some predicate has no accounts at all, so nothing is scanned
+	errNoAccounts = errors.New("no accounts")
+)
+
+// filterPredicates parses filter arguments into predicates and response properties inferred from them
+func filterPredicates(args *fasthttp.Args) ([]*Predicate, []string, error) {
+	// ignore interests, likes
+	responseProperties := make([]string, 0, 128)
+	responseProperties = append(responseProperties, "id", "email")
+
+	sexEqF := args.Peek("sex_eq")
 	if len(sexEqF) > 0 {
 		responseProperties = append(responseProperties, "sex")
 	}
 
-	emailDomainF := ctx.QueryArgs().Peek("email_domain")
-	emailLtF := ctx.QueryArgs().Peek("email_lt")
-	emailGtF := ctx.QueryArgs().Peek("email_gt")
+	emailDomainF := args.Peek("email_domain")
+	emailLtF := args.Peek("email_lt")
+	emailGtF := args.Peek("email_gt")
 	if len(emailLtF) > 0 || len(emailGtF) > 0 || len(emailDomainF) > 0 {
 		responseProperties = append(responseProperties, "email")
 	}
 
-	statusEqF := ctx.QueryArgs().Peek("status_eq")
-	statusNeqF := ctx.QueryArgs().Peek("status_neq")
+	statusEqF := args.Peek("status_eq")
+	statusNeqF := args.Peek("status_neq")
 	if len(statusEqF) > 0 || len(statusNeqF) > 0 {
 		responseProperties = append(responseProperties, "status")
 	}
 
-	fnameEqF := ctx.QueryArgs().Peek("fname_eq")
-	fnameAnyF := ctx.QueryArgs().Peek("fname_any")
-	fnameNullF := ctx.QueryArgs().Peek("fname_null")
-	if len(fnameEqF) > 0 || len(fnameAnyF) > 0 {
+	fnameEqF := args.Peek("fname_eq")
+	fnameAnyF := args.Peek("fname_any")
+	fnameNullF := args.Peek("fname_null")
+	fnameLikeF := args.Peek("fname_like")
+	fnameStartsF := args.Peek("fname_starts")
+	if len(fnameEqF) > 0 || len(fnameAnyF) > 0 || len(fnameLikeF) > 0 || len(fnameStartsF) > 0 {
 		responseProperties = append(responseProperties, "fname")
 	}
 	//
-	snameEqF := ctx.QueryArgs().Peek("sname_eq")
-	snameStartsF := ctx.QueryArgs().Peek("sname_starts")
-	snameNullF := ctx.QueryArgs().Peek("sname_null")
-	if len(snameEqF) > 0 || len(snameStartsF) > 0 {
+	snameEqF := args.Peek("sname_eq")
+	snameStartsF := args.Peek("sname_starts")
+	snameNullF := args.Peek("sname_null")
+	snameLikeF := args.Peek("sname_like")
+	if len(snameEqF) > 0 || len(snameStartsF) > 0 || len(snameLikeF) > 0 {
 		responseProperties = append(responseProperties, "sname")
 	}
 	//
-	phoneCodeF := ctx.QueryArgs().Peek("phone_code")
-	phoneNullF := ctx.QueryArgs().Peek("phone_null")
+	phoneCodeF := args.Peek("phone_code")
+	phoneNullF := args.Peek("phone_null")
 	if len(phoneCodeF) > 0 {
 		responseProperties = append(responseProperties, "phone")
 	}
 	//
-	countryEqF := ctx.QueryArgs().Peek("country_eq")
-	countryNullF := ctx.QueryArgs().Peek("country_null")
+	countryEqF := args.Peek("country_eq")
+	countryNullF := args.Peek("country_null")
 	if len(countryEqF) > 0 {
 		responseProperties = append(responseProperties, "country")
 	}
 	//
-	cityEqF := ctx.QueryArgs().Peek("city_eq")
-	cityAnyF := ctx.QueryArgs().Peek("city_any")
-	cityNullF := ctx.QueryArgs().Peek("city_null")
-	if len(cityEqF) > 0 || len(cityAnyF) > 0 {
+	cityEqF := args.Peek("city_eq")
+	cityAnyF := args.Peek("city_any")
+	cityNullF := args.Peek("city_null")
+	cityIeqF := args.Peek("city_ieq")
+	if len(cityEqF) > 0 || len(cityAnyF) > 0 || len(cityIeqF) > 0 {
 		responseProperties = append(responseProperties, "city")
 	}
 	//
-	birthLtF := ctx.QueryArgs().Peek("birth_lt")
-	birthGtF := ctx.QueryArgs().Peek("birth_gt")
-	birthYearF := ctx.QueryArgs().Peek("birth_year")
-	if len(birthLtF) > 0 || len(birthGtF) > 0 || len(birthYearF) > 0 {
+	birthLtF := args.Peek("birth_lt")
+	birthGtF := args.Peek("birth_gt")
+	birthYearF := args.Peek("birth_year")
+	ageLtF := args.Peek("age_lt")
+	ageGtF := args.Peek("age_gt")
+	if len(birthLtF) > 0 || len(birthGtF) > 0 || len(birthYearF) > 0 || len(ageLtF) > 0 || len(ageGtF) > 0 {
 		responseProperties = append(responseProperties, "birth")
 	}
-
-	interestsContainsF := ctx.QueryArgs().Peek("interests_contains")
-	interestsAnyF := ctx.QueryArgs().Peek("interests_any")
-
-	likesContainsF := ctx.QueryArgs().Peek("likes_contains")
-
-	premiumNowF := ctx.QueryArgs().Peek("premium_now")
-	premiumNullF := ctx.QueryArgs().Peek("premium_null")
-	if len(premiumNowF) > 0 {
+	//
+	joinedLtF := args.Peek("joined_lt")
+	joinedGtF := args.Peek("joined_gt")
+	joinedYearF := args.Peek("joined_year")
+	if len(joinedLtF) > 0 || len(joinedGtF) > 0 || len(joinedYearF) > 0 {
+		responseProperties = append(responseProperties, "joined")
+	}
+
+	interestsContainsF := args.Peek("interests_contains")
+	interestsAnyF := args.Peek("interests_any")
+
+	likesContainsF := args.Peek("likes_contains")
+	likesAnyF := args.Peek("likes_any")
+	likedByContainsF := args.Peek("liked_by_contains")
+	likedByAnyF := args.Peek("liked_by_any")
+	likesCountLtF := args.Peek("likes_count_lt")
+	likesCountGtF := args.Peek("likes_count_gt")
+	likedCountLtF := args.Peek("liked_count_lt")
+	likedCountGtF := args.Peek("liked_count_gt")
+
+	premiumNowF := args.Peek("premium_now")
+	premiumNullF := args.Peek("premium_null")
+	premiumFinishLtF := args.Peek("premium_finish_lt")
+	premiumFinishGtF := args.Peek("premium_finish_gt")
+	if len(premiumNowF) > 0 || len(premiumFinishLtF) > 0 || len(premiumFinishGtF) > 0 {
 		responseProperties = append(responseProperties, "premium")
 	}
 
-	var foundAccounts []*Account
-
-	filters := make(map[string]interface{})
-
-	var sexEqFilter string
-	if len(sexEqF) > 0 {
-		sexEqFilter = string(sexEqF)
-		filters["sex_eq"] = 1
-	}
-	var emailDomainFilter string
-	if len(emailDomainF) > 0 {
-		emailDomainFilter = string(emailDomainF)
-		filters["email_domain"] = 1
-	}
-	var emailLtFilter string
+	var emailLtFilter, emailGtFilter string
 	if len(emailLtF) > 0 {
 		emailLtFilter = string(emailLtF)
-		filters["email_lt"] = 1
 	}
-	var emailGtFilter string
 	if len(emailGtF) > 0 {
 		emailGtFilter = string(emailGtF)
-		filters["email_gt"] = 1
 	}
-	var birthYearFilter int
-	if len(birthYearF) > 0 {
-		birthYearFilter, _ = strconv.Atoi(string(birthYearF))
-		filters["birth_year"] = 1
+	var birthLtFilter, birthGtFilter, ageLtFilter, ageGtFilter *int
+	var joinedLtFilter, joinedGtFilter, premiumFinishLtFilter, premiumFinishGtFilter *int
+	var likesCountLtFilter, likesCountGtFilter, likedCountLtFilter, likedCountGtFilter *int
+	intFilters := []struct {
+		value  []byte
+		filter **int
+	}{
+		{birthLtF, &birthLtFilter},
+		{birthGtF, &birthGtFilter},
+		{ageLtF, &ageLtFilter},
+		{ageGtF, &ageGtFilter},
+		{joinedLtF, &joinedLtFilter},
+		{joinedGtF, &joinedGtFilter},
+		{premiumFinishLtF, &premiumFinishLtFilter},
+		{premiumFinishGtF, &premiumFinishGtFilter},
+		{likesCountLtF, &likesCountLtFilter},
+		{likesCountGtF, &likesCountGtFilter},
+		{likedCountLtF, &likedCountLtFilter},
+		{likedCountGtF, &likedCountGtFilter},
+	}
+	for _, intFilter := range intFilters {
+		if len(intFilter.value) == 0 {
+			continue
+		}
+		value, err := strconv.Atoi(string(intFilter.value))
+		if err != nil {
+			return nil, nil, errInvalidFilter
+		}
+		*intFilter.filter = &value
 	}
-	var birthLtFilter int
-	if len(birthLtF) > 0 {
-		birthLtFilter, _ = strconv.Atoi(string(birthLtF))
-		filters["birth_lt"] = 1
+
+	var predicates []*Predicate
+
+	// equality predicates over posting lists, unknown key means there are no such accounts
+	addPostingPredicate := func(name string, index *SafeIndex, key interface{}, match func(acc *Account) bool) bool {
+		predicate, ok := newPostingPredicate(name, index, key, match)
+		if ok {
+			predicates = append(predicates, predicate)
+		}
+		return ok
 	}
-	var birthGtFilter int
-	if len(birthGtF) > 0 {
-		birthGtFilter, _ = strconv.Atoi(string(birthGtF))
-		filters["birth_gt"] = 1
+
+	if len(emailDomainF) > 0 {
+		emailDomainFilter := string(emailDomainF)
+		if !addPostingPredicate("email_domain", emailDomainIndex, emailDomainFilter, func(acc *Account) bool {
+			return acc.emailDomain == emailDomainFilter
+		}) {
+			return nil, nil, errNoAccounts
+		}
 	}
-	var statusEqFilter string
-	if len(statusEqF) > 0 {
-		statusEqFilter = string(statusEqF)
-		filters["status_eq"] = 1
+	if len(phoneCodeF) > 0 {
+		phoneCodeFilter, _ := strconv.Atoi(string(phoneCodeF))
+		if !addPostingPredicate("phone_code", phoneCodeIndex, phoneCodeFilter, func(acc *Account) bool {
+			return acc.phoneCode == phoneCodeFilter
+		}) {
+			return nil, nil, errNoAccounts
+		}
 	}
-	var statusNeqFilter string
-	if len(statusNeqF) > 0 {
-		statusNeqFilter = string(statusNeqF)
-		filters["status_neq"] = 1
+	if len(countryEqF) > 0 {
+		countryEqFilter := string(countryEqF)
+		if !addPostingPredicate("country", countryIndex, countryEqFilter, func(acc *Account) bool {
+			return acc.Country == countryEqFilter
+		}) {
+			return nil, nil, errNoAccounts
+		}
 	}
-	var fnameNullFilter bool
-	var fnameNotNullFilter bool
-	var fnameEqFilter string
-	var fnameAnyFilter = make(map[string]int, 0)
-	if len(fnameEqF) > 0 {
-		fnameEqFilter = string(fnameEqF)
-		filters["fname_eq"] = 1
-	}
-	if len(fnameNullF) > 0 {
-		if string(fnameNullF) == "0" {
-			fnameNotNullFilter = true
-			filters["fname_not_null"] = 1
-			responseProperties = append(responseProperties, "fname")
-		} else {
-			fnameNullFilter = true
-			filters["fname_null"] = 1
+	if len(cityEqF) > 0 {
+		cityEqFilter := string(cityEqF)
+		if !addPostingPredicate("city", cityIndex, cityEqFilter, func(acc *Account) bool {
+			return acc.City == cityEqFilter
+		}) {
+			return nil, nil, errNoAccounts
 		}
-
 	}
-	if len(fnameAnyF) > 0 {
-		words := strings.Split(string(fnameAnyF), ",")
-		for _, word := range words {
-			fnameAnyFilter[word] = 1
+	if len(birthYearF) > 0 {
+		birthYearFilter, _ := strconv.Atoi(string(birthYearF))
+		if !addPostingPredicate("birth_year", birthYearIndex, birthYearFilter, func(acc *Account) bool {
+			return acc.birthYear == birthYearFilter
+		}) {
+			return nil, nil, errNoAccounts
 		}
-		filters["fname_any"] = 1
 	}
-	var snameNullFilter bool
-	var snameNotNullFilter bool
-	var snameEqFilter string
-	var snameStartsFilter string
-	if len(snameEqF) > 0 {
-		snameEqFilter = string(snameEqF)
-		filters["sname_eq"] = 1
-	}
-	if len(snameStartsF) > 0 {
-		snameStartsFilter = string(snameStartsF)
-		filters["sname_starts"] = 1
-	}
-	if len(snameNullF) > 0 {
-		if string(snameNullF) == "0" {
-			snameNotNullFilter = true
-			filters["sname_not_null"] = 1
-			responseProperties = append(responseProperties, "sname")
-		} else {
-			snameNullFilter = true
-			filters["sname_null"] = 1
+	if len(cityIeqF) > 0 {
+		cityIeqFilter := foldName(string(cityIeqF))
+		if !addPostingPredicate("city_ieq", normalizedCityIndex, cityIeqFilter, func(acc *Account) bool {
+			return foldName(acc.City) == cityIeqFilter
+		}) {
+			return nil, nil, errNoAccounts
 		}
-
 	}
-	var phoneNullFilter bool
-	var phoneNotNullFilter bool
-	var phoneCodeFilter int
-	if len(phoneNullF) > 0 {
-		if string(phoneNullF) == "0" {
-			phoneNotNullFilter = true
-			filters["phone_not_null"] = 1
-			responseProperties = append(responseProperties, "phone")
-		} else {
-			phoneNullFilter = true
-			filters["phone_null"] = 1
+	if len(joinedYearF) > 0 {
+		joinedYearFilter, _ := strconv.Atoi(string(joinedYearF))
+		if !addPostingPredicate("joined_year", joinedYearIndex, joinedYearFilter, func(acc *Account) bool {
+			return acc.joinedYear == joinedYearFilter
+		}) {
+			return nil, nil, errNoAccounts
 		}
-
 	}
-	if len(phoneCodeF) > 0 {
-		phoneCodeFilter, _ = strconv.Atoi(string(phoneCodeF))
-		filters["phone_code"] = 1
+	if len(snameEqF) > 0 {
+		snameEqFilter := string(snameEqF)
+		if !addPostingPredicate("sname", snameIndex, snameEqFilter, func(acc *Account) bool {
+			return acc.Sname == snameEqFilter
+		}) {
+			return nil, nil, errNoAccounts
+		}
 	}
-
-	var countryEqFilter string
-	var countryNullFilter bool
-	var countryNotNullFilter bool
-	if len(countryEqF) > 0 {
-		countryEqFilter = string(countryEqF)
-		filters["country_eq"] = 1
-	}
-	if len(countryNullF) > 0 {
-		if string(countryNullF) == "0" {
-			countryNotNullFilter = true
-			filters["country_not_null"] = 1
-			responseProperties = append(responseProperties, "country")
-		} else {
-			countryNullFilter = true
-			filters["country_null"] = 1
+	if len(fnameEqF) > 0 {
+		fnameEqFilter := string(fnameEqF)
+		if !addPostingPredicate("fname", fnameIndex, fnameEqFilter, func(acc *Account) bool {
+			return acc.Fname == fnameEqFilter
+		}) {
+			return nil, nil, errNoAccounts
 		}
+	}
+	if len(interestsContainsF) > 0 {
+		// every interest is a separate predicate, so the planner could intersect them
+		for _, word := range strings.Split(string(interestsContainsF), ",") {
+			interest := word
+			if !addPostingPredicate("interests_contains", interestsIndex, interest, func(acc *Account) bool {
+				_, ok := acc.interestsMap[interest]
+				return ok
+			}) {
+				return nil, nil, errNoAccounts
+			}
+		}
+	}
 
+	// low-cardinality predicates are resolved with bitmaps before planning
+	var bitmapQuery BitmapQuery
+	if len(sexEqF) > 0 {
+		bitmapQuery.And(sexBitmap.Get(string(sexEqF)))
 	}
-	var cityEqFilter string
-	var cityAnyFilter = make(map[string]int, 0)
-	var cityNullFilter bool
-	var cityNotNullFilter bool
-	if len(cityEqF) > 0 {
-		cityEqFilter = string(cityEqF)
-		filters["city_eq"] = 1
+	if len(statusEqF) > 0 {
+		bitmapQuery.And(statusBitmap.Get(string(statusEqF)))
 	}
-	if len(cityAnyF) > 0 {
-		words := strings.Split(string(cityAnyF), ",")
-		for _, word := range words {
-			cityAnyFilter[word] = 1
-		}
-		filters["city_any"] = 1
+	if len(statusNeqF) > 0 {
+		bitmapQuery.AndNot(statusBitmap.Get(string(statusNeqF)))
 	}
-	if len(cityNullF) > 0 {
-		if string(cityNullF) == "0" {
-			cityNotNullFilter = true
-			filters["city_not_null"] = 1
-			responseProperties = append(responseProperties, "city")
+	if bytes.Equal(premiumNowF, []byte("1")) {
+		bitmapQuery.And(flagsBitmap.Get(bitmapPremiumNow))
+	}
+	nullFilters := []struct {
+		field string
+		value []byte
+	}{
+		{"fname", fnameNullF},
+		{"sname", snameNullF},
+		{"phone", phoneNullF},
+		{"country", countryNullF},
+		{"city", cityNullF},
+		{"premium", premiumNullF},
+	}
+	for _, nullFilter := range nullFilters {
+		if len(nullFilter.value) == 0 {
+			continue
+		}
+		if string(nullFilter.value) == "0" {
+			bitmapQuery.And(notNullBitmap.Get(nullFilter.field))
+			responseProperties = append(responseProperties, nullFilter.field)
 		} else {
-			cityNullFilter = true
-			filters["city_null"] = 1
+			bitmapQuery.AndNot(notNullBitmap.Get(nullFilter.field))
 		}
-
 	}
-	var premiumNullFilter bool
-	var premiumNotNullFilter bool
-	var premiumNowFilter bool
-	if len(premiumNullF) > 0 {
-		if string(premiumNullF) == "0" {
-			premiumNotNullFilter = true
-			filters["premium_not_null"] = 1
-			responseProperties = append(responseProperties, "premium")
-		} else {
-			premiumNullFilter = true
-			filters["premium_null"] = 1
+	if !bitmapQuery.IsEmpty() {
+		bitmapFilter := bitmapQuery.Resolve(func() *Bitmap {
+			return flagsBitmap.Get(bitmapAll)
+		})
+		if bitmapFilter.IsEmpty() {
+			return nil, nil, errNoAccounts
 		}
+		predicates = append(predicates, newBitmapPredicate("bitmap", bitmapFilter, costBitmapLookup, func(acc *Account) bool {
+			return bitmapFilter.Contains(acc.ID)
+		}))
+	}
+
+	// likes of the account are found by likers of likees, likes to the account are in likes of likers
+	likesFilters := []struct {
+		name  string
+		value []byte
+		ids   func(ids []int) *Bitmap
+	}{
+		{"likes_contains", likesContainsF, func(ids []int) *Bitmap {
+			return likeeIndex.Likers(ids...)
+		}},
+		{"likes_any", likesAnyF, func(ids []int) *Bitmap {
+			result := NewBitmap()
+			for _, id := range ids {
+				result.OrWith(likeeIndex.Likers(id))
+			}
+			return result
+		}},
+		{"liked_by_contains", likedByContainsF, func(ids []int) *Bitmap {
+			result := likeesBitmap(ids[0])
+			for _, id := range ids[1:] {
+				result = result.And(likeesBitmap(id))
+			}
+			return result
+		}},
+		{"liked_by_any", likedByAnyF, func(ids []int) *Bitmap {
+			result := NewBitmap()
+			for _, id := range ids {
+				addLikees(result, id)
+			}
+			return result
+		}},
+	}
+	for _, likesFilter := range likesFilters {
+		ids := splitIds(likesFilter.value)
+		if len(ids) == 0 {
+			continue
+		}
+		candidates := likesFilter.ids(ids)
+		if candidates.IsEmpty() {
+			return nil, nil, errNoAccounts
+		}
+		predicates = append(predicates, newBitmapPredicate(likesFilter.name, candidates, costBitmapLookup, func(acc *Account) bool {
+			return candidates.Contains(acc.ID)
+		}))
+	}
+
+	// counts of likes are checked for every account, both count distinct accounts so repeated likes don't matter
+	countFilters := []struct {
+		name  string
+		gt    *int
+		lt    *int
+		count func(acc *Account) int
+	}{
+		{"likes_count", likesCountGtFilter, likesCountLtFilter, func(acc *Account) int {
+			return acc.likes.Distinct()
+		}},
+		{"liked_count", likedCountGtFilter, likedCountLtFilter, func(acc *Account) int {
+			return likeeIndex.Count(acc.ID)
+		}},
+	}
+	for _, countFilter := range countFilters {
+		if countFilter.gt == nil && countFilter.lt == nil {
+			continue
+		}
+		gt, lt, count := countFilter.gt, countFilter.lt, countFilter.count
+		predicates = append(predicates, newScanPredicate(countFilter.name, rangeSelectivity, costMapLookup, func(acc *Account) bool {
+			value := count(acc)
+			return (lt == nil || value < *lt) && (gt == nil || value > *gt)
+		}))
+	}
+
+	// name prefixes are looked up in tries
+	startsFilters := []struct {
+		name  string
+		trie  *PrefixTrie
+		value []byte
+		field func(acc *Account) string
+	}{
+		{"fname_starts", fnameTrie, fnameStartsF, func(acc *Account) string {
+			return acc.Fname
+		}},
+		{"sname_starts", snameTrie, snameStartsF, func(acc *Account) string {
+			return acc.Sname
+		}},
+	}
+	for _, startsFilter := range startsFilters {
+		if len(startsFilter.value) == 0 {
+			continue
+		}
+		prefix, field := string(startsFilter.value), startsFilter.field
+		candidates := startsFilter.trie.Prefix(prefix)
+		if candidates.IsEmpty() {
+			return nil, nil, errNoAccounts
+		}
+		predicates = append(predicates, newBitmapPredicate(startsFilter.name, candidates, costFieldCompare, func(acc *Account) bool {
+			return strings.HasPrefix(field(acc), prefix)
+		}))
+	}
+
+	// names with typos are found among distinct normalized names
+	likeFilters := []struct {
+		name    string
+		index   *SafeIndex
+		lengths *NameLengthIndex
+		value   []byte
+	}{
+		{"fname_like", normalizedFnameIndex, fnameLengths, fnameLikeF},
+		{"sname_like", normalizedSnameIndex, snameLengths, snameLikeF},
+	}
+	for _, likeFilter := range likeFilters {
+		if len(likeFilter.value) == 0 {
+			continue
+		}
+		candidates := likeBitmap(likeFilter.index, likeFilter.lengths, string(likeFilter.value))
+		if candidates.IsEmpty() {
+			return nil, nil, errNoAccounts
+		}
+		predicates = append(predicates, newBitmapPredicate(likeFilter.name, candidates, costBitmapLookup, func(acc *Account) bool {
+			return candidates.Contains(acc.ID)
+		}))
+	}
 
+	// predicates without index, selectivity is estimated by sizes of posting lists
+	if len(fnameAnyF) > 0 {
+		fnameAnyFilter := splitSet(fnameAnyF)
+		predicates = append(predicates, newScanPredicate("fname_any", postingsSelectivity(fnameIndex, fnameAnyFilter), costMapLookup, func(acc *Account) bool {
+			_, ok := fnameAnyFilter[acc.Fname]
+			return acc.Fname != "" && ok
+		}))
 	}
-	if bytes.Equal(premiumNowF, []byte("1")) {
-		premiumNowFilter = true
-		filters["premium_now"] = 1
+	if len(cityAnyF) > 0 {
+		cityAnyFilter := splitSet(cityAnyF)
+		predicates = append(predicates, newScanPredicate("city_any", postingsSelectivity(cityIndex, cityAnyFilter), costMapLookup, func(acc *Account) bool {
+			_, ok := cityAnyFilter[acc.City]
+			return acc.City != "" && ok
+		}))
 	}
-	var interestsAnyFilter map[string]struct{}
-	var interestsContainsFilter map[string]struct{}
 	if len(interestsAnyF) > 0 {
-		// 2 allocs costs
-		words := strings.Split(string(interestsAnyF), ",")
-		if len(words) > 0 {
-			interestsAnyFilter = map[string]struct{}{}
-			for _, word := range words {
-				interestsAnyFilter[word] = struct{}{}
-			}
-			filters["interests_any"] = 1
-		}
+		interestsAnyFilter := splitSet(interestsAnyF)
+		predicates = append(predicates, newScanPredicate("interests_any", postingsSelectivity(interestsIndex, interestsAnyFilter), costMapLookup*float64(len(interestsAnyFilter)), func(acc *Account) bool {
+			return filterAny(acc.interestsMap, interestsAnyFilter)
+		}))
 	}
-	if len(interestsContainsF) > 0 {
-		words := strings.Split(string(interestsContainsF), ",")
-		if len(words) > 0 {
-			interestsContainsFilter = map[string]struct{}{}
-			for _, word := range words {
-				interestsContainsFilter[word] = struct{}{}
+
+	// range indexes are used only when they are more selective than other predicates
+	if emailLtFilter != "" || emailGtFilter != "" {
+		var from, to interface{}
+		if emailGtFilter != "" {
+			from = emailGtFilter
+		}
+		if emailLtFilter != "" {
+			to = emailLtFilter
+		}
+		matchEmail := func(acc *Account) bool {
+			return (emailLtFilter == "" || acc.Email < emailLtFilter) && (emailGtFilter == "" || acc.Email > emailGtFilter)
+		}
+		if candidates := emailIndex.RangeBitmap(from, to, selectiveRangeLimit(predicates)); candidates != nil {
+			if candidates.IsEmpty() {
+				return nil, nil, errNoAccounts
 			}
-			filters["interests_contains"] = 1
+			predicates = append(predicates, newBitmapPredicate("email_range", candidates, costFieldCompare, matchEmail))
+		} else {
+			predicates = append(predicates, newScanPredicate("email_range", rangeSelectivity, costFieldCompare, matchEmail))
 		}
 	}
-	var likesContainsFilter []int
-	if len(likesContainsF) > 0 {
-		accIds := strings.Split(string(likesContainsF), ",")
-		for _, accId := range accIds {
-			if accId, err := strconv.Atoi(accId); err == nil {
-				likesContainsFilter = append(likesContainsFilter, accId)
-				filters["likes_contains"] = 1
+
+	// gt < value < lt over the timestamp index, accounts without value have no keys in the index
+	addTimestampRange := func(name string, index *OrderedIndex, gt, lt *int, field func(acc *Account) (int, bool)) bool {
+		match := func(acc *Account) bool {
+			value, ok := field(acc)
+			return ok && (lt == nil || value < *lt) && (gt == nil || value > *gt)
+		}
+		from, to := timestampRange(gt, lt)
+		if candidates := index.RangeBitmap(from, to, selectiveRangeLimit(predicates)); candidates != nil {
+			if candidates.IsEmpty() {
+				return false
 			}
+			predicates = append(predicates, newBitmapPredicate(name, candidates, costFieldCompare, match))
+		} else {
+			predicates = append(predicates, newScanPredicate(name, rangeSelectivity, costFieldCompare, match))
+		}
+		return true
+	}
+	birthField := func(acc *Account) (int, bool) {
+		return acc.Birth, true
+	}
+	// age is relative to the reference time, so it is a range of birth
+	ageBirthGt, ageBirthLt := ageToBirthRange(ageGtFilter, ageLtFilter, now)
+
+	timestampRanges := []struct {
+		name  string
+		index *OrderedIndex
+		gt    *int
+		lt    *int
+		field func(acc *Account) (int, bool)
+	}{
+		{"birth_range", birthIndex, birthGtFilter, birthLtFilter, birthField},
+		{"age_range", birthIndex, ageBirthGt, ageBirthLt, birthField},
+		{"joined_range", joinedIndex, joinedGtFilter, joinedLtFilter, func(acc *Account) (int, bool) {
+			return acc.Joined, true
+		}},
+		{"premium_finish_range", premiumIndex.finishes, premiumFinishGtFilter, premiumFinishLtFilter, func(acc *Account) (int, bool) {
+			finish, ok := acc.Premium["finish"]
+			return finish, ok
+		}},
+	}
+	for _, rangeFilter := range timestampRanges {
+		if rangeFilter.gt == nil && rangeFilter.lt == nil {
+			continue
+		}
+		if !addTimestampRange(rangeFilter.name, rangeFilter.index, rangeFilter.gt, rangeFilter.lt, rangeFilter.field) {
+			return nil, nil, errNoAccounts
 		}
 	}
 
-	var index *treemap.Map
+	return predicates, responseProperties, nil
+}
 
-	vnidxpool := namedIndexPool.Get()
-	namedIndex := vnidxpool.(*NamedIndex)
+func (options *FilterOptions) emptyResponse(ctx *fasthttp.RequestCtx) {
+	if options.count {
+		countResponse(ctx, 0)
+		return
+	}
+	emptyResponse(ctx)
+}
 
-	vmap := treemapPool.Get()
-	suitableIndexes := vmap.(*treemap.Map)
-	suitableIndexes.Put(accountIndex.Size(), namedIndex.Update([]byte("default"), accountIndex))
+func countResponse(ctx *fasthttp.RequestCtx, count int) {
+	ctx.Success("application/json", append(fasthttp.AppendUint([]byte(`{"count":`), count), '}'))
+}
 
-	if countryEqFilter != "" {
-		if countryIndex.Exists(countryEqFilter) {
-			currIndex := countryIndex.Get(countryEqFilter).(*treemap.Map)
-			suitableIndexes.Put(
-				currIndex.Size(),
-				namedIndex.Update([]byte("country"), currIndex),
-			)
-		} else {
-			// todo: return empty json
-			emptyResponse(ctx)
+// findFiltered plans and executes predicates, the page of found accounts is the response
+func findFiltered(ctx *fasthttp.RequestCtx, predicates []*Predicate, responseProperties []string, options *FilterOptions, explain *Explain) {
+	if options.count {
+		plan := planQuery(predicates, accountIndex.Size())
+		if explain != nil {
+			explain.Plan(plan)
+			plan.count(explain)
+			explainResponse(ctx, explain)
 			return
 		}
+		countResponse(ctx, plan.Count())
+		return
 	}
 
-	if cityEqFilter != "" {
-		if cityIndex.Exists(cityEqFilter) {
-			currIndex := cityIndex.Get(cityEqFilter).(*treemap.Map)
-			suitableIndexes.Put(
-				currIndex.Size(),
-				namedIndex.Update([]byte("city"), currIndex),
-			)
-		} else {
-			// todo: return empty json
-			emptyResponse(ctx)
-			return
-		}
+	limit, order := options.limit, options.order
+	// sorted results are selected from all matching accounts, so there is no limit for the plan
+	planLimit := limit
+	if order != nil {
+		planLimit = accountIndex.Size()
 	}
-
-	if birthYearFilter > 0 {
-		if birthYearIndex.Exists(birthYearFilter) {
-			currIndex := birthYearIndex.Get(birthYearFilter).(*treemap.Map)
-			suitableIndexes.Put(
-				currIndex.Size(),
-				namedIndex.Update([]byte("birth_year"), currIndex),
-			)
-		} else {
-			// todo: return empty json
-			emptyResponse(ctx)
-			return
-		}
+	if options.fields != nil {
+		responseProperties = projectFields(options.fields, responseProperties)
 	}
 
-	if snameEqFilter != "" {
-		if snameIndex.Exists(snameEqFilter) {
-			currIndex := snameIndex.Get(snameEqFilter).(*treemap.Map)
-			suitableIndexes.Put(
-				currIndex.Size(),
-				namedIndex.Update([]byte("sname"), currIndex),
-			)
+	plan := planQuery(predicates, planLimit)
+	if explain != nil {
+		explain.Plan(plan)
+		if order != nil {
+			plan.findSorted(limit, order, options.after, explain)
 		} else {
-			// todo: return empty json
-			emptyResponse(ctx)
-			return
+			plan.find(limit, options.cursor.ID, explain)
 		}
+		explainResponse(ctx, explain)
+		return
 	}
 
-	if fnameEqFilter != "" {
-		if !fnameIndex.Exists(fnameEqFilter) {
-			currIndex := fnameIndex.Get(fnameEqFilter).(*treemap.Map)
-			suitableIndexes.Put(
-				currIndex.Size(),
-				namedIndex.Update([]byte("fname"), currIndex),
-			)
-		} else {
-			// todo: return empty json
-			emptyResponse(ctx)
-			return
-		}
+	var foundAccounts []*Account
+	if order != nil {
+		foundAccounts = plan.FindSorted(limit, order, options.after)
+	} else {
+		foundAccounts = plan.FindBefore(limit, options.cursor.ID)
 	}
 
-	if len(interestsContainsFilter) > 0 {
-		// get shortest index
-		var shortInterestsIndex *treemap.Map
-		for interest := range interestsContainsFilter {
-			if !interestsIndex.Exists(interestsIndex) {
-				continue
-			}
-			if shortInterestsIndex == nil {
-				shortInterestsIndex = interestsIndex.Get(interest).(*treemap.Map)
-				continue
-			}
-			currIndex := interestsIndex.Get(interest).(*treemap.Map)
-			if shortInterestsIndex.Size() < currIndex.Size() {
-				shortInterestsIndex = currIndex
+	if len(foundAccounts) > 0 {
+		response := prepareResponseBytes(foundAccounts, responseProperties)
+		// a full page could be followed by the next one, it starts after the last returned account
+		if len(foundAccounts) == limit {
+			last := foundAccounts[len(foundAccounts)-1]
+			if order != nil {
+				response = appendCursor(response, order.Cursor(last))
+			} else {
+				response = appendCursor(response, FilterCursor{ID: last.ID})
 			}
 		}
-
-		if shortInterestsIndex != nil {
-			suitableIndexes.Put(
-				shortInterestsIndex.Size(),
-				namedIndex.Update([]byte("interests_contains"), shortInterestsIndex),
-			)
-		}
+		ctx.Success("application/json", response)
+		return
 	}
 
-	if len(likesContainsFilter) > 0 {
-		// get shortest index
-		var shortInterestsIndex *treemap.Map
-		for _, like := range likesContainsFilter {
-			if likeeIndex.Get(like) == nil {
-				continue
-			}
-			currIndex := likeeIndex.Get(like).(*treemap.Map)
-			if shortInterestsIndex == nil {
-				shortInterestsIndex = currIndex
-				continue
-			} else if shortInterestsIndex.Size() < currIndex.Size() {
-				shortInterestsIndex = currIndex
-			}
-		}
+	emptyResponse(ctx)
+}
 
-		if shortInterestsIndex != nil {
-			suitableIndexes.Put(
-				shortInterestsIndex.Size(),
-				namedIndex.Update([]byte("likes_contains"), shortInterestsIndex),
-			)
+// rangeSelectivity is a guess for ranges which were too wide to be collected
+const rangeSelectivity = 0.5
+
+// selectiveRangeLimit is the max amount of range index candidates which is still cheaper than the current best index
+func selectiveRangeLimit(predicates []*Predicate) int {
+	limit := accountIndex.Size() / 4
+	for _, predicate := range predicates {
+		if predicate.index != nil && predicate.index.Size() < limit {
+			limit = predicate.index.Size()
 		}
 	}
 
-	var selectedIndexName []byte
-	if suitableIndexes.Size() > 0 {
-		if _, shortestIndex := suitableIndexes.Min(); &shortestIndex != nil {
-			res := shortestIndex.(*NamedIndex)
-			selectedIndexName = res.name
-			index = res.index
+	return limit
+}
+
+// postingsSelectivity is a share of accounts which have any of keys
+func postingsSelectivity(index *SafeIndex, keys map[string]struct{}) float64 {
+	total := 0
+	for key := range keys {
+		if index.Exists(key) {
+			total += index.Get(key).(*treemap.Map).Size()
 		}
 	}
 
-	namedIndexPool.Put(vnidxpool)
-	treemapPool.Put(vmap)
+	return selectivity(total)
+}
 
-	filtersCount := len(filters)
+func splitSet(value []byte) map[string]struct{} {
+	words := strings.Split(string(value), ",")
+	set := make(map[string]struct{}, len(words))
+	for _, word := range words {
+		set[word] = struct{}{}
+	}
 
-	if index != nil {
-		it := index.Iterator()
-		for it.Next() {
-			if len(foundAccounts) >= limit {
-				break
-			}
-			passedFilters := 0
-			account := it.Value().(*Account)
-			if sexEqFilter != "" {
-				if account.Sex == sexEqFilter {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if len(statusEqFilter) > 0 {
-				if account.Status == statusEqFilter {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if len(statusNeqFilter) > 0 {
-				if account.Status != statusNeqFilter {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if fnameEqFilter != "" {
-				// use const for index name
-				if bytes.Equal(selectedIndexName, []byte("fname")) || account.Fname == fnameEqFilter {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if fnameNullFilter {
-				if account.Fname == "" {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			} else if fnameNotNullFilter {
-				if account.Fname != "" {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if len(fnameAnyFilter) > 0 {
-				fname := account.Fname
-				if len(fname) == 0 {
-					continue
-				}
-				if _, ok := fnameAnyFilter[fname]; ok {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if snameEqFilter != "" {
-				// use const for index name
-				if bytes.Equal(selectedIndexName, []byte("sname")) || account.Sname == snameEqFilter {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			} else if snameStartsFilter != "" {
-				// slow
-				// use const for index name
-				//FIXME: slow solution
-				if strings.HasPrefix(account.Sname, snameStartsFilter) {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if snameNullFilter {
-				if account.Sname == "" {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			} else if snameNotNullFilter {
-				if account.Sname != "" {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if phoneNullFilter {
-				if account.Phone == "" {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			} else if phoneNotNullFilter {
-				if account.Phone != "" {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if phoneCodeFilter > 0 {
-				if account.phoneCode == phoneCodeFilter {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if countryEqFilter != "" {
-				// use const for index name
-				if bytes.Equal(selectedIndexName, []byte("country")) || account.Country == countryEqFilter {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			//FIXME: group null/not-null filters
-			if countryNullFilter {
-				if account.Country == "" {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			} else if countryNotNullFilter {
-				if account.Country != "" {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if cityEqFilter != "" {
-				// use const for index name
-				if bytes.Equal(selectedIndexName, []byte("city")) || account.City == cityEqFilter {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if cityNullFilter {
-				if account.City == "" {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			} else if cityNotNullFilter {
-				if account.City != "" {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if len(cityAnyFilter) > 0 {
-				// FIXME: slow solution
-				accountCity := account.City
-				if len(accountCity) == 0 {
-					continue
-				}
-				if _, ok := cityAnyFilter[accountCity]; ok {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if len(interestsAnyFilter) > 0 {
-				if filterAny(account.interestsMap, interestsAnyFilter) {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if len(interestsContainsFilter) > 0 {
-				// FIXME: slow solution
-				if filterContains(interestsContainsFilter, account.interestsMap) {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if len(likesContainsFilter) > 0 {
-				// FIXME: slow solution
-				suitable := true
-				for _, v := range likesContainsFilter {
-					if _, ok := account.likes[v]; !ok {
-						suitable = false
-						break
-					}
-				}
-				if suitable {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if len(emailLtFilter) > 0 {
-				if account.Email < emailLtFilter {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			} else if len(emailGtFilter) > 0 {
-				if account.Email > emailGtFilter {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if len(emailDomainFilter) > 0 {
-				if account.emailDomain == emailDomainFilter {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if birthYearFilter > 0 {
-				// use const for index name
-				if bytes.Equal(selectedIndexName, []byte("birth_year")) || account.birthYear == birthYearFilter {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if birthLtFilter > 0 {
-				if account.Birth < birthLtFilter {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			} else if birthGtFilter > 0 {
-				if account.Birth > birthGtFilter {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if premiumNullFilter {
-				if len(account.Premium) == 0 {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			} else if premiumNotNullFilter {
-				if len(account.Premium) > 0 {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if premiumNowFilter {
-				if account.hasActivePremium(now) {
-					passedFilters += 1
-				} else {
-					continue
-				}
-			}
-			if passedFilters == filtersCount {
-				foundAccounts = append(foundAccounts, account)
-			}
+	return set
+}
+
+// splitIds parses a list of ids, values which aren't numbers are skipped
+func splitIds(value []byte) []int {
+	if len(value) == 0 {
+		return nil
+	}
+	var ids []int
+	for _, word := range strings.Split(string(value), ",") {
+		if id, err := strconv.Atoi(word); err == nil {
+			ids = append(ids, id)
 		}
 	}
 
-	if len(foundAccounts) > 0 {
-		ctx.Success("application/json", prepareResponseBytes(foundAccounts, responseProperties))
-		return
+	return ids
+}
+
+// ageToBirthRange converts optional "older than ageGt" / "younger than ageLt" years
+// at the reference time into gt < birth < lt bounds
+func ageToBirthRange(ageGt, ageLt *int, reference int64) (birthGt, birthLt *int) {
+	referenceTime := time.Unix(reference, 0).In(time.UTC)
+	if ageLt != nil {
+		// age < ageLt: born after the ageLt-th birthday moment
+		gt := int(referenceTime.AddDate(-*ageLt, 0, 0).Unix())
+		birthGt = &gt
+	}
+	if ageGt != nil {
+		// age > ageGt: turned ageGt+1 not later than the reference time
+		lt := int(referenceTime.AddDate(-*ageGt-1, 0, 0).Unix()) + 1
+		birthLt = &lt
 	}
 
-	emptyResponse(ctx)
-	return
+	return birthGt, birthLt
+}
+
+// likeesBitmap returns ids of accounts which were liked by the liker
+func likeesBitmap(likerId int) *Bitmap {
+	result := NewBitmap()
+	addLikees(result, likerId)
+
+	return result
+}
+
+// addLikees adds ids of accounts which were liked by the liker to the bitmap
+func addLikees(bitmap *Bitmap, likerId int) {
+	if value, found := accountIndex.Get(likerId); found {
+		for _, like := range value.(*Account).likes {
+			bitmap.Add(int(like.ID))
+		}
+	}
 }
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var idPattern = regexp.MustCompile(`"id":(\d+)`)
+
+// ids of accounts put by putFilterTestAccounts, a test could add its own accounts in the rest of its range
+const filterTestIdRange = 10000
+
+var nextFilterTestId = 990200000
+
+func TestFilterHandlerCombinesIndexes(t *testing.T) {
+	firstId, cleanup := putFilterTestAccounts()
+	defer cleanup()
+
+	cases := map[string][]int{
+		"interests_contains=filter_a,filter_b&limit=10":                {4, 2, 0},
+		"interests_contains=filter_b&fname_eq=Фильтр&limit=10":         {2, 0},
+		"interests_contains=filter_a&sex_eq=m&fname_eq=Фильтр&limit=2": {3, 2},
+		"interests_contains=filter_a,filter_unknown&limit=10":          {},
+	}
+	for query, expected := range cases {
+		ctx := testRequest("GET", "/accounts/filter/?"+query, "")
+		requestHandler(ctx)
+		var ids []string
+		for _, i := range expected {
+			ids = append(ids, fmt.Sprint(firstId+i))
+		}
+		var found []string
+		for _, match := range idPattern.FindAllStringSubmatch(string(ctx.Response.Body()), -1) {
+			found = append(found, match[1])
+		}
+		if strings.Join(found, ",") != strings.Join(ids, ",") {
+			t.Errorf("%s: expected %v, got %v", query, ids, found)
+		}
+	}
+}
+
+func TestFilterHandlerCursor(t *testing.T) {
+	firstId, cleanup := putFilterTestAccounts()
+	defer cleanup()
+
+	query := "interests_contains=filter_a&limit=2"
+	if pages := filterPages(t, firstId, query); fmt.Sprint(pages) != "[[5 4] [3 2] [1 0] []]" {
+		t.Error("unexpected pages", pages)
+	}
+
+	// the next page starts after the cursor id, so a new account with a higher id isn't returned twice
+	// and a removed account of the next page doesn't shift it
+	var added, removed *Account
+	writeLocked(func() {
+		added = &Account{ID: firstId + 6, Email: "filter6@test.local", Sex: "m"}
+		added.interestsMap = map[string]struct{}{"filter_a": {}}
+		added.updateDerivedFields()
+		indexAccount(added)
+		accountIndex.Put(added.ID, added)
+
+		value, _ := accountIndex.Get(firstId + 3)
+		removed = value.(*Account)
+		unindexAccount(removed)
+		accountIndex.Remove(removed.ID)
+	})
+	if pages := filterPagesFrom(t, firstId, query, FilterCursor{ID: firstId + 4}.String()); fmt.Sprint(pages) != "[[2 1] [0]]" {
+		t.Error("unexpected pages after changes", pages)
+	}
+	if pages := filterPages(t, firstId, query); fmt.Sprint(pages) != "[[6 5] [4 2] [1 0] []]" {
+		t.Error("unexpected pages from the start", pages)
+	}
+	writeLocked(func() {
+		unindexAccount(added)
+		accountIndex.Remove(added.ID)
+		indexAccount(removed)
+		accountIndex.Put(removed.ID, removed)
+	})
+
+	ctx := testRequest("GET", "/accounts/filter/?"+query+"&cursor=abc", "")
+	requestHandler(ctx)
+	if ctx.Response.StatusCode() != 400 {
+		t.Error("expected 400 for invalid cursor, got", ctx.Response.StatusCode())
+	}
+}
+
+func TestFilterHandlerFields(t *testing.T) {
+	firstId, cleanup := putFilterTestAccounts()
+	defer cleanup()
+
+	cases := map[string]string{
+		"fields=interests,likes_count":             `{"id":%d,"interests":["filter_a","filter_b"],"likes_count":0}`,
+		"fields=*,joined&fname_eq=Другой":          `{"id":%d,"email":"filter4@test.local","fname":"Другой","joined":1433116800}`,
+		"fields=sex,id,sex&interests_any=filter_b": `{"id":%d,"sex":"m"}`,
+	}
+	for query, expected := range cases {
+		expected = fmt.Sprintf(expected, firstId+4)
+		ctx := testRequest("GET", "/accounts/filter/?interests_contains=filter_b&limit=1&cursor="+FilterCursor{ID: firstId + 5}.String()+"&"+query, "")
+		requestHandler(ctx)
+		var response struct{ Accounts []json.RawMessage }
+		if err := json.Unmarshal(ctx.Response.Body(), &response); err != nil || len(response.Accounts) != 1 {
+			t.Fatal(query, err, string(ctx.Response.Body()))
+		}
+		if string(response.Accounts[0]) != expected {
+			t.Errorf("%s: expected %s, got %s", query, expected, response.Accounts[0])
+		}
+	}
+
+	ctx := testRequest("GET", "/accounts/filter/?limit=1&fields=password", "")
+	requestHandler(ctx)
+	if ctx.Response.StatusCode() != 400 {
+		t.Error("expected 400 for unknown field, got", ctx.Response.StatusCode())
+	}
+}
+
+func TestFilterHandlerTimestampRanges(t *testing.T) {
+	firstId, cleanup := putFilterTestAccounts()
+	defer cleanup()
+	reference := now
+	setNow(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Unix())
+	defer setNow(reference)
+
+	year := func(year int) int64 {
+		return time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	}
+	cases := map[string]string{
+		"joined_year=2013":                                               "[[2]]",
+		fmt.Sprintf("joined_gt=%d", year(2014)):                          "[[5 4 3]]",
+		fmt.Sprintf("joined_gt=%d&joined_lt=%d", year(2012), year(2015)): "[[3 2 1]]",
+		"age_lt=38":           "[[5 4 3 2]]",
+		"age_gt=37&age_lt=40": "[[1 0]]",
+		fmt.Sprintf("premium_finish_lt=%d", year(2016)): "[[3]]",
+		fmt.Sprintf("premium_finish_gt=%d", year(2016)): "[[5 4]]",
+		"joined_year=2000": "[[]]",
+	}
+	for query, expected := range cases {
+		if pages := filterPages(t, firstId, "interests_contains=filter_a&limit=10&"+query); fmt.Sprint(pages) != expected {
+			t.Errorf("%s: expected %s, got %v", query, expected, pages)
+		}
+	}
+
+	// the range filters project the field they were applied to, age is a range of birth
+	joined := func(i int) int64 {
+		return time.Date(2011+i, 6, 1, 0, 0, 0, 0, time.UTC).Unix()
+	}
+	responses := map[string]string{
+		"joined_year=2013": fmt.Sprintf(`{"id":%d,"email":"filter2@test.local","joined":%d}`, firstId+2, joined(2)),
+		"age_gt=38":        fmt.Sprintf(`{"id":%d,"email":"filter0@test.local","birth":%d}`, firstId, time.Date(1980, 6, 1, 0, 0, 0, 0, time.UTC).Unix()),
+		fmt.Sprintf("premium_finish_lt=%d", year(2016)): fmt.Sprintf(`{"id":%d,"email":"filter3@test.local","premium":{"start":%d,"finish":%d}}`,
+			firstId+3, joined(3), joined(3)+86400*365),
+	}
+	for query, expected := range responses {
+		ctx := testRequest("GET", "/accounts/filter/?limit=10&"+query, "")
+		requestHandler(ctx)
+		if expected = `{"accounts":[` + expected + `]}`; string(ctx.Response.Body()) != expected {
+			t.Errorf("%s: expected %s, got %s", query, expected, ctx.Response.Body())
+		}
+	}
+}
+
+func TestFilterHandlerCount(t *testing.T) {
+	firstId, cleanup := putFilterTestAccounts()
+	defer cleanup()
+
+	cases := map[string]string{
+		"/accounts/filter/?interests_contains=filter_a&count=1":                      `{"count":6}`,
+		"/accounts/filter/count/?interests_contains=filter_b&sex_eq=m":               `{"count":3}`,
+		"/accounts/filter/count/?interests_contains=filter_a&fname_any=Другой,Никто": `{"count":1}`,
+		"/accounts/filter/?interests_contains=filter_a&country_eq=Nowhere&count=1":   `{"count":0}`,
+		"/accounts/filter/?interests_contains=filter_a&count=1&limit=1&sort=birth":   `{"count":6}`,
+		"/accounts/filter/?interests_contains=filter_a&count=0&limit=1&fields=id":    fmt.Sprintf(`{"accounts":[{"id":%d}],"cursor":"%s"}`, firstId+5, FilterCursor{ID: firstId + 5}),
+	}
+	for uri, expected := range cases {
+		ctx := testRequest("GET", uri, "")
+		requestHandler(ctx)
+		if string(ctx.Response.Body()) != expected {
+			t.Errorf("%s: expected %s, got %s", uri, expected, ctx.Response.Body())
+		}
+	}
+
+	ctx := testRequest("GET", "/accounts/filter/?count=2", "")
+	requestHandler(ctx)
+	if ctx.Response.StatusCode() != 400 {
+		t.Error("expected 400 for invalid count, got", ctx.Response.StatusCode())
+	}
+
+	ctx = testRequest("POST", "/accounts/query/", `{"where": {"and": [{"interests_contains": "filter_a"}, {"or": [{"interests_contains": "filter_b"}, {"sex_eq": "f"}]}]}, "count": true}`)
+	requestHandler(ctx)
+	if string(ctx.Response.Body()) != `{"count":4}` {
+		t.Error("unexpected count of query", string(ctx.Response.Body()))
+	}
+}
+
+func TestFilterHandlerNormalizedNames(t *testing.T) {
+	firstId, cleanup := putFilterTestAccounts()
+	defer cleanup()
+
+	cases := map[string]string{
+		"city_ieq=москва":            "[[1]]",
+		"city_ieq=МОСКВА":            "[[1]]",
+		"city_ieq=москв":             "[[]]",
+		"fname_like=Фмльтр":          "[[5 3 2 1 0]]",
+		"fname_like=другои":          "[[4]]",
+		"fname_like=ДРУГОЙ&sex_eq=m": "[[4]]",
+		"fname_like=Анна":            "[[]]",
+	}
+	for query, expected := range cases {
+		if pages := filterPages(t, firstId, "interests_contains=filter_a&limit=10&"+query); fmt.Sprint(pages) != expected {
+			t.Errorf("%s: expected %s, got %v", query, expected, pages)
+		}
+	}
+
+	// names are matched by folded keys, but responses keep them as they were written
+	responses := map[string]string{
+		"city_ieq=МОСКВА":   fmt.Sprintf(`{"id":%d,"email":"filter1@test.local","city":"Москва"}`, firstId+1),
+		"fname_like=другои": fmt.Sprintf(`{"id":%d,"email":"filter4@test.local","fname":"Другой"}`, firstId+4),
+	}
+	for query, expected := range responses {
+		ctx := testRequest("GET", "/accounts/filter/?limit=10&"+query, "")
+		requestHandler(ctx)
+		if expected = `{"accounts":[` + expected + `]}`; string(ctx.Response.Body()) != expected {
+			t.Errorf("%s: expected %s, got %s", query, expected, ctx.Response.Body())
+		}
+	}
+}
+
+func TestFilterHandlerLikes(t *testing.T) {
+	firstId, cleanup := putFilterTestAccounts()
+	defer cleanup()
+
+	// liker -> likees by offsets from firstId, 3 likes 2 twice
+	likes := map[int][]int{0: {1, 2}, 1: {2}, 3: {2, 2}, 4: {1}}
+	writeLocked(func() {
+		for liker, likees := range likes {
+			value, _ := accountIndex.Get(firstId + liker)
+			acc := value.(*Account)
+			reindexAccount(acc, []string{"likes"}, func() {
+				for i, likee := range likees {
+					acc.AppendLike(firstId+likee, 1500000000+i)
+				}
+			})
+		}
+	})
+
+	ids := func(offsets ...int) string {
+		var ids []string
+		for _, offset := range offsets {
+			ids = append(ids, fmt.Sprint(firstId+offset))
+		}
+		return strings.Join(ids, ",")
+	}
+	cases := map[string]string{
+		"likes_contains=" + ids(2):          "[[3 1 0]]",
+		"likes_contains=" + ids(1, 2):       "[[0]]",
+		"likes_any=" + ids(1, 2):            "[[4 3 1 0]]",
+		"liked_by_contains=" + ids(0, 1):    "[[2]]",
+		"liked_by_any=" + ids(0, 4):         "[[2 1]]",
+		"liked_by_any=" + ids(5):            "[[]]",
+		"likes_count_gt=1":                  "[[0]]",
+		"likes_count_lt=2":                  "[[5 4 3 2 1]]",
+		"liked_count_gt=1":                  "[[2 1]]",
+		"liked_count_gt=2&liked_count_lt=4": "[[2]]",
+		"liked_count_lt=1&likes_count_lt=1": "[[5]]",
+	}
+	for query, expected := range cases {
+		if pages := filterPages(t, firstId, "interests_contains=filter_a&limit=10&"+query); fmt.Sprint(pages) != expected {
+			t.Errorf("%s: expected %s, got %v", query, expected, pages)
+		}
+	}
+
+	// the repeated like is counted once both by the filter and by the response field
+	ctx := testRequest("GET", "/accounts/filter/?likes_contains="+ids(2)+"&likes_count_lt=2&fields=likes_count&limit=10", "")
+	requestHandler(ctx)
+	expected := fmt.Sprintf(`{"accounts":[{"id":%d,"likes_count":1},{"id":%d,"likes_count":1}]}`, firstId+3, firstId+1)
+	if body := string(ctx.Response.Body()); body != expected {
+		t.Errorf("likes_count of repeated likes: expected %s, got %s", expected, body)
+	}
+}
+
+func TestFilterHandlerAgeBoundary(t *testing.T) {
+	firstId, cleanup := putFilterTestAccounts()
+	defer cleanup()
+	reference := now
+	// the first account turns 39 exactly at the reference time
+	setNow(time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC).Unix())
+	defer setNow(reference)
+
+	birth := func(i int) int64 {
+		return time.Date(1980+i, 6, 1, 0, 0, 0, 0, time.UTC).Unix()
+	}
+
+	cases := map[string]string{
+		"age_gt=38":           "[[0]]",
+		"age_gt=39":           "[[]]",
+		"age_lt=39":           "[[5 4 3 2 1]]",
+		"age_gt=36&age_lt=39": "[[2 1]]",
+		// birth ranges exclude their bounds
+		fmt.Sprintf("birth_gt=%d", birth(2)):                       "[[5 4 3]]",
+		fmt.Sprintf("birth_lt=%d", birth(2)):                       "[[1 0]]",
+		fmt.Sprintf("birth_gt=%d&birth_lt=%d", birth(1), birth(3)): "[[2]]",
+		fmt.Sprintf("birth_gt=%d&birth_lt=%d", birth(2), birth(3)): "[[]]",
+	}
+	for query, expected := range cases {
+		if pages := filterPages(t, firstId, "interests_contains=filter_a&limit=10&"+query); fmt.Sprint(pages) != expected {
+			t.Errorf("%s: expected %s, got %v", query, expected, pages)
+		}
+	}
+
+	ctx := testRequest("GET", "/accounts/filter/?limit=10&age_lt=x", "")
+	requestHandler(ctx)
+	if ctx.Response.StatusCode() != 400 {
+		t.Error("invalid age: expected 400, got", ctx.Response.StatusCode())
+	}
+}
+
+func TestFilterHandlerNameStarts(t *testing.T) {
+	firstId, cleanup := putFilterTestAccounts()
+	defer cleanup()
+	writeLocked(func() {
+		value, _ := accountIndex.Get(firstId + 2)
+		value.(*Account).Update(map[string]interface{}{"sname": "Фильтрова"})
+	})
+
+	cases := map[string]string{
+		"fname_starts=Ф":                "[[5 3 2 1 0]]",
+		"fname_starts=Дру":              "[[4]]",
+		"fname_starts=Фильтра":          "[[]]",
+		"sname_starts=Фильтр":           "[[2]]",
+		"fname_starts=Ф&sname_starts=Ф": "[[2]]",
+		"fname_starts=Д&sname_starts=Ф": "[[]]",
+	}
+	for query, expected := range cases {
+		if pages := filterPages(t, firstId, "interests_contains=filter_a&limit=10&"+query); fmt.Sprint(pages) != expected {
+			t.Errorf("%s: expected %s, got %v", query, expected, pages)
+		}
+	}
+
+	// the prefix filter projects the name it was applied to
+	ctx := testRequest("GET", "/accounts/filter/?fname_starts=Дру&limit=10", "")
+	requestHandler(ctx)
+	if expected := fmt.Sprintf(`{"accounts":[{"id":%d,"email":"filter4@test.local","fname":"Другой"}]}`, firstId+4); string(ctx.Response.Body()) != expected {
+		t.Errorf("fname_starts response: expected %s, got %s", expected, ctx.Response.Body())
+	}
+}
+
+// filterPages follows cursors of the filter query and returns offsets of found ids from firstId
+func filterPages(t *testing.T, firstId int, query string) [][]int {
+	return filterPagesFrom(t, firstId, query, "")
+}
+
+// filterPagesFrom is filterPages which starts with the page after the cursor
+func filterPagesFrom(t *testing.T, firstId int, query string, cursor string) [][]int {
+	var pages [][]int
+	if cursor != "" {
+		cursor = "&cursor=" + cursor
+	}
+	for len(pages) < 10 {
+		ctx := testRequest("GET", "/accounts/filter/?"+query+cursor, "")
+		requestHandler(ctx)
+		if ctx.Response.StatusCode() != 200 {
+			t.Fatal("unexpected status", ctx.Response.StatusCode())
+		}
+		var response struct {
+			Accounts []struct{ ID int }
+			Cursor   string
+		}
+		if err := json.Unmarshal(ctx.Response.Body(), &response); err != nil {
+			t.Fatal(err, string(ctx.Response.Body()))
+		}
+		page := []int{}
+		for _, acc := range response.Accounts {
+			page = append(page, acc.ID-firstId)
+		}
+		pages = append(pages, page)
+		if response.Cursor == "" {
+			break
+		}
+		cursor = "&cursor=" + response.Cursor
+	}
+
+	return pages
+}
+
+// putFilterTestAccounts indexes 6 accounts, all of them have interest filter_a and even ones have filter_b, the last one is female
+// and the first one lives in Москва.
+// The i-th account was born in 1980+i and joined in 2011+i, accounts from the 3rd one have premium for a year since joined.
+// Every call takes the next range of ids, so tests which put the accounts never share them.
+func putFilterTestAccounts() (firstId int, cleanup func()) {
+	firstId = nextFilterTestId
+	nextFilterTestId += filterTestIdRange
+
+	var created []*Account
+	writeLocked(func() {
+		for i := 0; i < 6; i++ {
+			acc := &Account{ID: firstId + i, Email: fmt.Sprintf("filter%d@test.local", i), Fname: "Фильтр", Sex: "m"}
+			acc.interestsMap = map[string]struct{}{"filter_a": {}}
+			if i%2 == 0 {
+				acc.interestsMap["filter_b"] = struct{}{}
+			}
+			if i == 4 {
+				acc.Fname = "Другой"
+			}
+			if i == 1 {
+				acc.City = "Москва"
+			}
+			if i == 5 {
+				acc.Sex = "f"
+			}
+			acc.Birth = int(time.Date(1980+i, 6, 1, 0, 0, 0, 0, time.UTC).Unix())
+			acc.Joined = int(time.Date(2011+i, 6, 1, 0, 0, 0, 0, time.UTC).Unix())
+			if i >= 3 {
+				acc.Premium = map[string]int{"start": acc.Joined, "finish": acc.Joined + 86400*365}
+			}
+			acc.updateDerivedFields()
+			indexAccount(acc)
+			accountIndex.Put(acc.ID, acc)
+			created = append(created, acc)
+		}
+	})
+
+	return firstId, func() {
+		writeLocked(func() {
+			for _, acc := range created {
+				unindexAccount(acc)
+				accountIndex.Remove(acc.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const sortParam = "sort"
+
+var errInvalidSort = errors.New("invalid sort")
+
+type sortField struct {
+	numeric bool
+	value   func(acc *Account) sortValue
+}
+
+var sortFields = map[string]sortField{
+	"birth": {true, func(acc *Account) sortValue {
+		return sortValue{num: acc.Birth}
+	}},
+	"joined": {true, func(acc *Account) sortValue {
+		return sortValue{num: acc.Joined}
+	}},
+	"premium_finish": {true, func(acc *Account) sortValue {
+		return sortValue{num: acc.Premium["finish"]}
+	}},
+	"email": {false, func(acc *Account) sortValue {
+		return sortValue{str: acc.Email}
+	}},
+	"fname": {false, func(acc *Account) sortValue {
+		return sortValue{str: acc.Fname}
+	}},
+	"sname": {false, func(acc *Account) sortValue {
+		return sortValue{str: acc.Sname}
+	}},
+}
+
+// sortValue is a sort key of one account, only one of the fields is used by a field
+type sortValue struct {
+	num int
+	str string
+}
+
+func (v sortValue) compare(other sortValue) int {
+	switch {
+	case v.num < other.num || v.num == other.num && v.str < other.str:
+		return -1
+	case v.num > other.num || v.str > other.str:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// FilterSort orders accounts by a field, ties are ordered by id in the same direction
+type FilterSort struct {
+	sortField
+	desc bool
+}
+
+// parseFilterSort parses sort=field or sort=-field for descending order
+func parseFilterSort(value []byte) (*FilterSort, error) {
+	name := string(value)
+	desc := strings.HasPrefix(name, "-")
+	name = strings.TrimPrefix(name, "-")
+	field, ok := sortFields[name]
+	if !ok {
+		return nil, errInvalidSort
+	}
+
+	return &FilterSort{sortField: field, desc: desc}, nil
+}
+
+// compare returns a negative number when a goes before b
+func (s *FilterSort) compare(a sortValue, aId int, b sortValue, bId int) int {
+	result := a.compare(b)
+	if result == 0 {
+		result = aId - bId
+	}
+	if s.desc {
+		return -result
+	}
+
+	return result
+}
+
+// Cursor is a position of the account in the sort order
+func (s *FilterSort) Cursor(acc *Account) FilterCursor {
+	value := s.value(acc)
+	key := value.str
+	if s.numeric {
+		key = strconv.Itoa(value.num)
+	}
+
+	return FilterCursor{ID: acc.ID, Key: key, keyed: true}
+}
+
+// position is the cursor account in the sort order, its key is decoded according to the field
+func (s *FilterSort) position(cursor FilterCursor) (*sortedAccount, error) {
+	if !cursor.keyed {
+		return nil, errInvalidCursor
+	}
+	position := &sortedAccount{acc: &Account{ID: cursor.ID}, value: sortValue{str: cursor.Key}}
+	if s.numeric {
+		num, err := strconv.Atoi(cursor.Key)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		position.value = sortValue{num: num}
+	}
+
+	return position, nil
+}
+
+type sortedAccount struct {
+	acc   *Account
+	value sortValue
+}
+
+// topAccounts keeps limit first accounts of the sort order, the last of them is on top of the heap
+type topAccounts struct {
+	order    *FilterSort
+	limit    int
+	accounts []sortedAccount
+}
+
+func (t *topAccounts) Len() int {
+	return len(t.accounts)
+}
+
+func (t *topAccounts) Less(i, j int) bool {
+	a, b := t.accounts[i], t.accounts[j]
+	return t.order.compare(a.value, a.acc.ID, b.value, b.acc.ID) > 0
+}
+
+func (t *topAccounts) Swap(i, j int) {
+	t.accounts[i], t.accounts[j] = t.accounts[j], t.accounts[i]
+}
+
+func (t *topAccounts) Push(x interface{}) {
+	t.accounts = append(t.accounts, x.(sortedAccount))
+}
+
+func (t *topAccounts) Pop() interface{} {
+	last := t.accounts[len(t.accounts)-1]
+	t.accounts = t.accounts[:len(t.accounts)-1]
+
+	return last
+}
+
+func (t *topAccounts) Add(acc *Account) {
+	value := t.order.value(acc)
+	if len(t.accounts) < t.limit {
+		heap.Push(t, sortedAccount{acc, value})
+		return
+	}
+	last := t.accounts[0]
+	if t.order.compare(value, acc.ID, last.value, last.acc.ID) < 0 {
+		t.accounts[0] = sortedAccount{acc, value}
+		heap.Fix(t, 0)
+	}
+}
+
+func (t *topAccounts) Sorted() []*Account {
+	sort.Sort(sort.Reverse(t))
+	found := make([]*Account, len(t.accounts))
+	for i, sorted := range t.accounts {
+		found[i] = sorted.acc
+	}
+
+	return found
+}
+
+// FindSorted returns up to limit accounts which follow the position in the sort order,
+// every matching account is checked but only limit of them are kept
+func (plan *QueryPlan) FindSorted(limit int, order *FilterSort, after *sortedAccount) []*Account {
+	return plan.findSorted(limit, order, after, nil)
+}
+
+func (plan *QueryPlan) findSorted(limit int, order *FilterSort, after *sortedAccount, explain *Explain) []*Account {
+	top := &topAccounts{order: order, limit: limit, accounts: make([]sortedAccount, 0, limit)}
+	plan.execute(explain, func(acc *Account) bool {
+		if after == nil || order.compare(order.value(acc), acc.ID, after.value, after.acc.ID) > 0 {
+			top.Add(acc)
+		}
+		return true
+	})
+
+	return top.Sorted()
+}
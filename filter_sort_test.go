@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestTopAccounts(t *testing.T) {
+	order, err := parseFilterSort([]byte("-birth"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var accounts []*Account
+	top := &topAccounts{order: order, limit: 10}
+	for i := 1; i <= 1000; i++ {
+		acc := &Account{ID: i, Birth: rand.Intn(100)}
+		accounts = append(accounts, acc)
+		top.Add(acc)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		a, b := accounts[i], accounts[j]
+		return a.Birth > b.Birth || a.Birth == b.Birth && a.ID > b.ID
+	})
+
+	if fmt.Sprint(top.Sorted()) != fmt.Sprint(accounts[:10]) {
+		t.Error("top accounts differ from sorted ones")
+	}
+
+	if _, err := parseFilterSort([]byte("phone")); err == nil {
+		t.Error("expected error for unsupported sort field")
+	}
+}
+
+func TestFilterHandlerSort(t *testing.T) {
+	firstId, cleanup := putFilterTestAccounts()
+	defer cleanup()
+
+	cases := map[string]string{
+		"sort=fname&limit=10":          "[[4 0 1 2 3 5]]",
+		"sort=-fname&limit=4":          "[[5 3 2 1] [0 4]]",
+		"sort=-email&limit=3":          "[[5 4 3] [2 1 0] []]",
+		"sort=fname&limit=2":           "[[4 0] [1 2] [3 5] []]",
+		"sort=-fname&sex_eq=m&limit=2": "[[3 2] [1 0] [4]]",
+	}
+	for query, expected := range cases {
+		if pages := filterPages(t, firstId, "interests_contains=filter_a&"+query); fmt.Sprint(pages) != expected {
+			t.Errorf("%s: expected %s, got %v", query, expected, pages)
+		}
+	}
+
+	// cursors of the id scan have no sort key
+	ctx := testRequest("GET", "/accounts/filter/?sort=fname&limit=1&cursor="+FilterCursor{ID: firstId}.String(), "")
+	requestHandler(ctx)
+	if ctx.Response.StatusCode() != 400 {
+		t.Error("expected 400 for cursor without sort key, got", ctx.Response.StatusCode())
+	}
+}
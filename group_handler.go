@@ -7,7 +7,6 @@ import (
 
 	"github.com/emirpasic/gods/sets/treeset"
 
-	"github.com/emirpasic/gods/maps/treemap"
 	"github.com/valyala/fasthttp"
 )
 
@@ -175,14 +174,6 @@ func groupHandler(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	vnidxpool := namedIndexPool.Get()
-	namedIndex := vnidxpool.(*NamedIndex)
-
-	vmap := treemapPool.Get()
-	suitableIndexes := vmap.(*treemap.Map)
-
-	suitableIndexes.Put(accountIndex.Size(), namedIndex.Update([]byte("default"), accountIndex))
-
 	groupKeys := treeset.NewWithStringComparator()
 	keysF := ctx.QueryArgs().Peek("keys")
 	hasInterestsKey := false
@@ -203,213 +194,181 @@ func groupHandler(ctx *fasthttp.RequestCtx) {
 		hasInterestsKey = groupKeys.Contains("interests")
 	}
 
-	sexF := ctx.QueryArgs().Peek("sex")
-	var sexFilter string
-	if len(sexF) > 0 { //TODO: Add validation
-		sexFilter = string(sexF)
-	}
-
-	emailF := ctx.QueryArgs().Peek("email")
-	var emailFilter string
-	if len(emailF) > 0 { //TODO: Add validation
-		emailFilter = string(emailF)
-	}
-
-	statusF := ctx.QueryArgs().Peek("status")
-	var statusFilter string
-	if len(statusF) > 0 { //TODO: Add validation
-		statusFilter = string(statusF)
-	}
-
-	fnameF := ctx.QueryArgs().Peek("fname")
-	var fnameFilter string
-	if len(fnameF) > 0 { //TODO: Add validation
-		fnameFilter = string(fnameF)
-	}
-
-	snameF := ctx.QueryArgs().Peek("sname")
-	var snameFilter string
-	if len(snameF) > 0 { //TODO: Add validation
-		snameFilter = string(snameF)
-	}
-
-	phoneF := ctx.QueryArgs().Peek("phone")
-	var phoneFilter string
-	if len(phoneF) > 0 { //TODO: Add validation
-		phoneFilter = string(phoneF)
-	}
-
-	countryF := ctx.QueryArgs().Peek("country")
-	var countryFilter string
-	if len(countryF) > 0 { //TODO: Add validation
-		countryFilter = string(countryF)
-	}
-
-	cityF := ctx.QueryArgs().Peek("city")
-	var cityFilter string
-	if len(cityF) > 0 { //TODO: Add validation
-		cityFilter = string(cityF)
-	}
-
-	birthF := ctx.QueryArgs().Peek("birth")
-	var birthFilter int
-	if len(birthF) > 0 { //TODO: Add validation
-		birthFilter, _ = strconv.Atoi(string(birthF))
-		if birthYearIndex.Exists(birthFilter) {
-			currIndex := birthYearIndex.Get(birthFilter).(*treemap.Map)
-			suitableIndexes.Put(
-				currIndex.Size(),
-				namedIndex.Update([]byte("birth_year"), currIndex),
-			)
-		} else {
-			emptyGroupResponse(ctx)
+	explain := newExplain(ctx, "group")
+
+	var predicates []*Predicate
+	// sex and status are kept only in bitmaps
+	bitmapFilters := []struct {
+		name  string
+		index *BitmapIndex
+		value []byte
+		match func(acc *Account, value string) bool
+	}{
+		{"sex", sexBitmap, ctx.QueryArgs().Peek("sex"), func(acc *Account, value string) bool {
+			return acc.Sex == value
+		}},
+		{"status", statusBitmap, ctx.QueryArgs().Peek("status"), func(acc *Account, value string) bool {
+			return acc.Status == value
+		}},
+	}
+	for _, filter := range bitmapFilters {
+		if len(filter.value) == 0 { //TODO: Add validation
+			continue
+		}
+		value, match := string(filter.value), filter.match
+		predicate, ok := newBitmapIndexPredicate(filter.name, filter.index, value, func(acc *Account) bool {
+			return match(acc, value)
+		})
+		if !ok {
+			emptyExplainedResponse(ctx, explain, emptyGroupResponse)
+			return
+		}
+		predicates = append(predicates, predicate)
+	}
+
+	// equality filters over posting lists, unknown key means there are no such accounts
+	postingFilters := []struct {
+		name  string
+		index *SafeIndex
+		value []byte
+		match func(acc *Account, value string) bool
+	}{
+		{"fname", fnameIndex, ctx.QueryArgs().Peek("fname"), func(acc *Account, value string) bool {
+			return acc.Fname == value
+		}},
+		{"sname", snameIndex, ctx.QueryArgs().Peek("sname"), func(acc *Account, value string) bool {
+			return acc.Sname == value
+		}},
+		{"country", countryIndex, ctx.QueryArgs().Peek("country"), func(acc *Account, value string) bool {
+			return acc.Country == value
+		}},
+		{"city", cityIndex, ctx.QueryArgs().Peek("city"), func(acc *Account, value string) bool {
+			return acc.City == value
+		}},
+		{"interests", interestsIndex, ctx.QueryArgs().Peek("interests"), func(acc *Account, value string) bool {
+			_, ok := acc.interestsMap[value]
+			return ok
+		}},
+	}
+	for _, filter := range postingFilters {
+		if len(filter.value) == 0 { //TODO: Add validation
+			continue
+		}
+		value, match := string(filter.value), filter.match
+		predicate, ok := newPostingPredicate(filter.name, filter.index, value, func(acc *Account) bool {
+			return match(acc, value)
+		})
+		if !ok {
+			emptyExplainedResponse(ctx, explain, emptyGroupResponse)
+			return
+		}
+		predicates = append(predicates, predicate)
+	}
+
+	yearFilters := []struct {
+		name  string
+		index *SafeIndex
+		value []byte
+		match func(acc *Account, year int) bool
+	}{
+		{"birth_year", birthYearIndex, ctx.QueryArgs().Peek("birth"), func(acc *Account, year int) bool {
+			return acc.birthYear == year
+		}},
+		{"joined", joinedYearIndex, ctx.QueryArgs().Peek("joined"), func(acc *Account, year int) bool {
+			return acc.joinedYear == year
+		}},
+	}
+	for _, filter := range yearFilters {
+		if len(filter.value) == 0 { //TODO: Add validation
+			continue
+		}
+		year, err := strconv.Atoi(string(filter.value))
+		if err != nil {
+			ctx.Error("{}", 400)
+			return
+		}
+		match := filter.match
+		predicate, ok := newPostingPredicate(filter.name, filter.index, year, func(acc *Account) bool {
+			return match(acc, year)
+		})
+		if !ok {
+			emptyExplainedResponse(ctx, explain, emptyGroupResponse)
 			return
 		}
+		predicates = append(predicates, predicate)
 	}
 
-	joinedF := ctx.QueryArgs().Peek("joined")
-	var joinedFilter int
-	if len(joinedF) > 0 { //TODO: Add validation
-		joinedFilter, _ = strconv.Atoi(string(joinedF))
+	// unique values, so at most one account passes
+	if emailF := ctx.QueryArgs().Peek("email"); len(emailF) > 0 {
+		emailFilter := string(emailF)
+		predicates = append(predicates, newScanPredicate("email", selectivity(1), costFieldCompare, func(acc *Account) bool {
+			return acc.Email == emailFilter
+		}))
 	}
-
-	interestsF := ctx.QueryArgs().Peek("interests")
-	var interestsFilter string
-	if len(interestsF) > 0 { //TODO: Add validation
-		interestsFilter = string(interestsF)
+	if phoneF := ctx.QueryArgs().Peek("phone"); len(phoneF) > 0 {
+		phoneFilter := string(phoneF)
+		predicates = append(predicates, newScanPredicate("phone", selectivity(1), costFieldCompare, func(acc *Account) bool {
+			return acc.Phone == phoneFilter
+		}))
 	}
 
-	likesF := ctx.QueryArgs().Peek("likes")
-	var likesFilter int
-	if len(likesF) > 0 { //TODO: Add validation
-		likesFilter, _ = strconv.Atoi(string(likesF))
+	if likesF := ctx.QueryArgs().Peek("likes"); len(likesF) > 0 {
+		likesFilter, err := strconv.Atoi(string(likesF))
+		if err != nil {
+			ctx.Error("{}", 400)
+			return
+		}
+		likers := likeeIndex.Likers(likesFilter)
+		if likers.IsEmpty() {
+			emptyExplainedResponse(ctx, explain, emptyGroupResponse)
+			return
+		}
+		predicates = append(predicates, newBitmapPredicate("likes", likers, costBitmapLookup, func(acc *Account) bool {
+			return likers.Contains(acc.ID)
+		}))
 	}
 	//todo: add premium filter support?
 
-	var index *treemap.Map
-	//TODO: Select index by filter
-
-	var selectedIndexName []byte
-	if suitableIndexes.Size() > 0 {
-		if _, shortestIndex := suitableIndexes.Min(); &shortestIndex != nil {
-			res := shortestIndex.(*NamedIndex)
-			selectedIndexName = res.name
-			index = res.index
-		}
+	// all matched accounts are grouped, so there is no limit for the plan
+	plan := planQuery(predicates, accountIndex.Size())
+	if explain != nil {
+		explain.Plan(plan)
 	}
 
-	_ = selectedIndexName
-
-	namedIndexPool.Put(vnidxpool)
-	treemapPool.Put(vmap)
-
 	var foundGroups = make(map[string]int)
 
-	if index != nil {
-		it := index.Iterator()
-		for it.Next() {
-			account := it.Value().(*Account)
-
-			// conditions
-			if len(sexFilter) > 0 { //TODO: move len from loop
-				if account.Sex != sexFilter {
-					continue
-				}
-			}
-
-			if len(emailFilter) > 0 {
-				if account.Email != emailFilter {
-					continue
-				}
-			}
-
-			if len(statusFilter) > 0 {
-				if account.Status != statusFilter {
-					continue
-				}
-			}
-
-			if len(fnameFilter) > 0 {
-				if account.Fname != fnameFilter {
-					continue
-				}
-			}
-
-			if len(snameFilter) > 0 {
-				if account.Sname != snameFilter {
-					continue
-				}
+	plan.execute(explain, func(account *Account) bool {
+		// key grouping
+		var resultKey string
+		groupKeys.Each(func(index int, value interface{}) {
+			keyName := value.(string)
+			switch keyName {
+			case "sex":
+				resultKey += "_" + keyName + ":" + account.Sex
+			case "status":
+				resultKey += "_" + keyName + ":" + account.Status
+			case "country":
+				resultKey += "_" + keyName + ":" + account.Country
+			case "city":
+				resultKey += "_" + keyName + ":" + account.City
 			}
+		})
 
-			if len(phoneFilter) > 0 {
-				if account.Phone != phoneFilter {
-					continue
-				}
-			}
-
-			if len(countryFilter) > 0 {
-				if account.Country != countryFilter {
-					continue
-				}
-			}
-
-			if len(cityFilter) > 0 {
-				if account.City != cityFilter {
-					continue
-				}
-			}
-
-			if birthFilter != 0 {
-				if account.birthYear != birthFilter {
-					continue
-				}
-			}
-
-			if joinedFilter != 0 {
-				if account.joinedYear != joinedFilter {
-					continue
-				}
-			}
-
-			if interestsFilter != "" {
-				if _, ok := account.interestsMap[interestsFilter]; !ok {
-					continue
-				}
+		if hasInterestsKey {
+			for interest := range account.interestsMap {
+				interestsKey := resultKey + "_interests:" + interest
+				foundGroups[interestsKey] += 1
 			}
+		} else if len(resultKey) > 0 {
+			foundGroups[resultKey[1:]] += 1
+		}
 
-			if likesFilter != 0 {
-				if _, ok := account.likes[likesFilter]; !ok {
-					continue
-				}
-			}
+		return true
+	})
 
-			//TODO: Premium?
-
-			// key grouping
-			var resultKey string
-			groupKeys.Each(func(index int, value interface{}) {
-				keyName := value.(string)
-				switch keyName {
-				case "sex":
-					resultKey += "_" + keyName + ":" + account.Sex
-				case "status":
-					resultKey += "_" + keyName + ":" + account.Status
-				case "country":
-					resultKey += "_" + keyName + ":" + account.Country
-				case "city":
-					resultKey += "_" + keyName + ":" + account.City
-				}
-			})
-
-			if hasInterestsKey {
-				for interest := range account.interestsMap {
-					interestsKey := resultKey + "_interests:" + interest
-					foundGroups[interestsKey] += 1
-				}
-			} else if len(resultKey) > 0 {
-				foundGroups[resultKey[1:]] += 1
-			}
-		}
+	if explain != nil {
+		explainResponse(ctx, explain)
+		return
 	}
 
 	if len(foundGroups) > 0 {
@@ -443,8 +402,7 @@ func emptyGroupResponse(ctx *fasthttp.RequestCtx) {
 }
 
 func prepareGroupResponseBytes(found []*Group) []byte {
-	vbuf := bytesPool.Get()
-	bytesBuffer := vbuf.([]byte)
+	bytesBuffer := bytesPool.Get().([]byte)
 
 	bytesBuffer = append(bytesBuffer, `{"groups":[`...)
 
@@ -483,7 +441,10 @@ func prepareGroupResponseBytes(found []*Group) []byte {
 
 	bytesBuffer = append(bytesBuffer, `]}`...)
 
-	bytesPool.Put(vbuf)
+	// the pooled buffer is reused by parallel requests, so the response is its copy
+	response := make([]byte, len(bytesBuffer))
+	copy(response, bytesBuffer)
+	bytesPool.Put(bytesBuffer[:0])
 
-	return bytesBuffer
+	return response
 }
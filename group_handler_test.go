@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestGroupHandlerUnknownValues(t *testing.T) {
+	_, cleanup := putFilterTestAccounts()
+	defer cleanup()
+
+	cases := map[string]string{
+		"keys=sex&interests=filter_b":                     `{"groups":[{"count":3,"sex":"m"}]}`,
+		"keys=sex&interests=filter_b&country=Неизвестная": `{"groups":[]}`,
+		"keys=sex&interests=filter_b&sex=x":               `{"groups":[]}`,
+		"keys=city&interests=filter_a&city=Неизвестный":   `{"groups":[]}`,
+	}
+	for query, expected := range cases {
+		ctx := testRequest("GET", "/accounts/group/?order=1&limit=10&"+query, "")
+		requestHandler(ctx)
+		if body := string(ctx.Response.Body()); body != expected {
+			t.Errorf("%s: expected %s, got %s", query, expected, body)
+		}
+	}
+}
+
+func TestGroupHandlerInvalidNumbers(t *testing.T) {
+	_, cleanup := putFilterTestAccounts()
+	defer cleanup()
+
+	cases := map[string]int{
+		"joined=abc":  400,
+		"birth=19x0":  400,
+		"likes=abc":   400,
+		"birth=1982":  200,
+		"joined=2013": 200,
+	}
+	for query, expected := range cases {
+		ctx := testRequest("GET", "/accounts/group/?keys=sex&order=1&limit=10&interests=filter_a&"+query, "")
+		requestHandler(ctx)
+		if ctx.Response.StatusCode() != expected {
+			t.Errorf("%s: expected %d, got %d", query, expected, ctx.Response.StatusCode())
+		}
+	}
+
+	ctx := testRequest("GET", "/accounts/group/?keys=sex&order=1&limit=10&interests=filter_a&birth=1982", "")
+	requestHandler(ctx)
+	if body := string(ctx.Response.Body()); body != `{"groups":[{"count":1,"sex":"m"}]}` {
+		t.Error("birth=1982:", body)
+	}
+}
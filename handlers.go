@@ -1,25 +1,112 @@
 package main
 
 import (
+	"sort"
+
 	"github.com/emirpasic/gods/maps/treemap"
 
 	"github.com/valyala/fasthttp"
 )
 
 type NamedIndex struct {
-	name  []byte
-	index *treemap.Map
+	name   []byte
+	index  *treemap.Map
+	bitmap *Bitmap
 }
 
 func (n NamedIndex) New(name []byte, index *treemap.Map) *NamedIndex {
-	return &NamedIndex{name, index}
+	return &NamedIndex{name: name, index: index}
+}
+
+func newBitmapNamedIndex(name []byte, bitmap *Bitmap) *NamedIndex {
+	return &NamedIndex{name: name, bitmap: bitmap}
 }
 
-func (n *NamedIndex) Update(name []byte, index *treemap.Map) *NamedIndex {
-	n.name = name
-	n.index = index
+func (n *NamedIndex) Size() int {
+	if n.bitmap != nil {
+		return n.bitmap.Cardinality()
+	}
+
+	return n.index.Size()
+}
+
+// Bitmap returns ids of the index, posting lists are converted
+func (n *NamedIndex) Bitmap() *Bitmap {
+	if n.bitmap != nil {
+		return n.bitmap
+	}
+	result := NewBitmap()
+	for _, id := range n.index.Keys() {
+		result.Add(id.(int))
+	}
+
+	return result
+}
+
+type accountIterator interface {
+	Next() bool
+	Value() interface{}
+}
+
+// Iterator walks accounts of the index in descending id order
+func (n *NamedIndex) Iterator() accountIterator {
+	if n.bitmap != nil {
+		return &bitmapAccountIterator{ids: n.bitmap.ReverseIterator()}
+	}
+	it := n.index.Iterator()
+
+	return &it
+}
+
+// IteratorBefore walks accounts with ids lower than before, posting lists are entered at the cursor
+func (n *NamedIndex) IteratorBefore(before int) accountIterator {
+	if n.bitmap != nil {
+		return &bitmapAccountIterator{ids: n.bitmap.ReverseIteratorBefore(before)}
+	}
+
+	return &postingIteratorBefore{index: n.index, before: before}
+}
 
-	return n
+// postingIteratorBefore seeks every next id by the tree, so a page costs its size and not the offset.
+// Postings are ordered by inverseIntComparator, so the ceiling is the highest id not above the key.
+type postingIteratorBefore struct {
+	index  *treemap.Map
+	before int
+	value  interface{}
+}
+
+func (it *postingIteratorBefore) Next() bool {
+	key, value := it.index.Ceiling(it.before - 1)
+	if key == nil {
+		return false
+	}
+	it.before, it.value = key.(int), value
+
+	return true
+}
+
+func (it *postingIteratorBefore) Value() interface{} {
+	return it.value
+}
+
+type bitmapAccountIterator struct {
+	ids     *BitmapIterator
+	account *Account
+}
+
+func (it *bitmapAccountIterator) Next() bool {
+	for it.ids.Next() {
+		if account, ok := accountIndex.Get(it.ids.Value()); ok {
+			it.account = account.(*Account)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (it *bitmapAccountIterator) Value() interface{} {
+	return it.account
 }
 
 func emptyResponse(ctx *fasthttp.RequestCtx) {
@@ -27,8 +114,7 @@ func emptyResponse(ctx *fasthttp.RequestCtx) {
 }
 
 func prepareResponseBytes(found []*Account, responseProperties []string) []byte {
-	vbuf := bytesPool.Get()
-	bytesBuffer := vbuf.([]byte)
+	bytesBuffer := bytesPool.Get().([]byte)
 
 	bytesBuffer = append(bytesBuffer, `{"accounts":[`...)
 
@@ -83,6 +169,23 @@ func prepareResponseBytes(found []*Account, responseProperties []string) []byte
 					bytesBuffer = fasthttp.AppendUint(bytesBuffer, account.Premium["finish"])
 					bytesBuffer = append(bytesBuffer, `}`...)
 				}
+			case "joined":
+				bytesBuffer = append(bytesBuffer, `,"joined":`...)
+				bytesBuffer = fasthttp.AppendUint(bytesBuffer, account.Joined)
+			case "interests":
+				if len(account.interestsMap) > 0 {
+					bytesBuffer = append(bytesBuffer, `,"interests":[`...)
+					for i, interest := range sortedInterests(account) {
+						if i > 0 {
+							bytesBuffer = append(bytesBuffer, `,`...)
+						}
+						bytesBuffer = append(bytesBuffer, `"`+interest+`"`...)
+					}
+					bytesBuffer = append(bytesBuffer, `]`...)
+				}
+			case "likes_count":
+				bytesBuffer = append(bytesBuffer, `,"likes_count":`...)
+				bytesBuffer = fasthttp.AppendUint(bytesBuffer, account.likes.Distinct())
 			}
 
 			if lastKey {
@@ -97,7 +200,21 @@ func prepareResponseBytes(found []*Account, responseProperties []string) []byte
 
 	bytesBuffer = append(bytesBuffer, `]}`...)
 
-	bytesPool.Put(vbuf)
+	// the pooled buffer is reused by parallel requests, so the response is its copy
+	response := make([]byte, len(bytesBuffer))
+	copy(response, bytesBuffer)
+	bytesPool.Put(bytesBuffer[:0])
+
+	return response
+}
+
+// sortedInterests are interests of the account in a stable order, they are stored as a set
+func sortedInterests(account *Account) []string {
+	interests := make([]string, 0, len(account.interestsMap))
+	for interest := range account.interestsMap {
+		interests = append(interests, interest)
+	}
+	sort.Strings(interests)
 
-	return bytesBuffer
+	return interests
 }
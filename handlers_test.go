@@ -1,8 +1,10 @@
 package main
 
 import (
+	"reflect"
 	"testing"
 
+	"github.com/emirpasic/gods/maps/treemap"
 	"github.com/valyala/fasthttp"
 )
 
@@ -64,3 +66,43 @@ func BenchmarkContains(b *testing.B) {
 		filterContains(needle, haystack)
 	}
 }
+
+func TestNamedIndexIteratorBefore(t *testing.T) {
+	posting := treemap.NewWith(inverseIntComparator)
+	for id := 10; id <= 100; id += 10 {
+		posting.Put(id, &Account{ID: id})
+	}
+	index := NamedIndex{}.New([]byte("posting"), posting)
+
+	cases := map[int][]int{
+		1000: {100, 90, 80, 70, 60, 50, 40, 30, 20, 10},
+		55:   {50, 40, 30, 20, 10},
+		50:   {40, 30, 20, 10},
+		10:   nil,
+	}
+	for before, expected := range cases {
+		var ids []int
+		for it := index.IteratorBefore(before); it.Next(); {
+			ids = append(ids, it.Value().(*Account).ID)
+		}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("before %d: expected %v, got %v", before, expected, ids)
+		}
+	}
+}
+
+func BenchmarkPostingIteratorBefore(b *testing.B) {
+	posting := treemap.NewWith(inverseIntComparator)
+	for id := 1; id <= 100000; id++ {
+		posting.Put(id, &Account{ID: id})
+	}
+	index := NamedIndex{}.New([]byte("posting"), posting)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		// a page of 20 deep in the posting list
+		it := index.IteratorBefore(1000)
+		for i := 0; i < 20 && it.Next(); i++ {
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+)
+
+const indexCheckExamples = 10
+
+// IndexCheckResult is a diff between expected contents of the index built from accounts and the live one
+type IndexCheckResult struct {
+	Name     string   `json:"name"`
+	Missing  int      `json:"missing"`
+	Stale    int      `json:"stale"`
+	Examples []string `json:"examples,omitempty"`
+	Repaired bool     `json:"repaired"`
+}
+
+func (result IndexCheckResult) IsConsistent() bool {
+	return result.Missing == 0 && result.Stale == 0
+}
+
+type indexEntry struct {
+	key interface{}
+	acc *Account
+}
+
+// checkIndexes compares every registered index with the accounts,
+// when repair is set missing entries are added and stale ones are removed
+func checkIndexes(repair bool) []IndexCheckResult {
+	lock := readLocked
+	if repair {
+		lock = writeLocked
+	}
+
+	results := make([]IndexCheckResult, 0, len(secondaryIndexes))
+	lock(func() {
+		for _, index := range secondaryIndexes {
+			results = append(results, checkIndex(index, repair))
+		}
+	})
+
+	return results
+}
+
+func checkIndex(index *SecondaryIndex, repair bool) IndexCheckResult {
+	result := IndexCheckResult{Name: index.name}
+	example := func(format string, args ...interface{}) {
+		if len(result.Examples) < indexCheckExamples {
+			result.Examples = append(result.Examples, fmt.Sprintf(format, args...))
+		}
+	}
+
+	var missing []indexEntry
+	it := accountIndex.Iterator()
+	for it.Next() {
+		acc := it.Value().(*Account)
+		for _, key := range index.keys(acc) {
+			if !index.store.contains(key, acc) {
+				missing = append(missing, indexEntry{key, acc})
+				example("missing %v -> %d", key, acc.ID)
+			}
+		}
+	}
+
+	// stores are locked while enumerated, so stale entries are collected and removed afterwards
+	var stale []indexEntry
+	index.store.each(func(key interface{}, id int) {
+		if value, ok := accountIndex.Get(id); ok {
+			for _, expectedKey := range index.keys(value.(*Account)) {
+				if expectedKey == key {
+					return
+				}
+			}
+			stale = append(stale, indexEntry{key, value.(*Account)})
+		} else {
+			stale = append(stale, indexEntry{key, &Account{ID: id}})
+		}
+		example("stale %v -> %d", key, id)
+	})
+
+	result.Missing = len(missing)
+	result.Stale = len(stale)
+
+	if repair && !result.IsConsistent() {
+		for _, entry := range stale {
+			index.store.remove(entry.key, entry.acc)
+		}
+		for _, entry := range missing {
+			index.store.put(entry.key, entry.acc)
+		}
+		result.Repaired = true
+	}
+
+	return result
+}
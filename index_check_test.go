@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/emirpasic/gods/maps/treemap"
+)
+
+func TestCheckIndexRepair(t *testing.T) {
+	acc := &Account{ID: 990000002, Phone: "8(990)0000002"}
+	acc.interestsMap = map[string]struct{}{"check_a": {}}
+	indexAccount(acc)
+	accountIndex.Put(acc.ID, acc)
+	defer func() {
+		unindexAccount(acc)
+		accountIndex.Remove(acc.ID)
+	}()
+
+	// drift: stale interest entry, missing one and a phone stored not as account
+	postingStore{interestsIndex}.put("check_stale", acc)
+	interestsIndex.Get("check_a").(*treemap.Map).Remove(acc.ID)
+	phoneIndex.Update(acc.Phone, 1)
+
+	for name, expected := range map[string][2]int{"interests": {1, 1}, "phone": {1, 1}} {
+		result := checkIndex(lookupIndex(name), true)
+		if result.Missing != expected[0] || result.Stale != expected[1] || !result.Repaired {
+			t.Errorf("%s: unexpected check result %+v", name, result)
+		}
+		if result = checkIndex(lookupIndex(name), false); !result.IsConsistent() {
+			t.Errorf("%s: index was not repaired %+v", name, result)
+		}
+	}
+
+	if phoneIndex.Get(acc.Phone) != acc {
+		t.Error("phone index value was not repaired")
+	}
+}
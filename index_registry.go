@@ -0,0 +1,392 @@
+package main
+
+import (
+	"github.com/emirpasic/gods/maps/treemap"
+)
+
+// Secondary indexes are declared once with a key extractor, insert / update / delete
+// of accounts are applied to every registered index by diffing extracted keys.
+// Fields are the account fields which keys depend on, an update only diffs indexes of changed fields.
+
+type indexStore interface {
+	put(key interface{}, acc *Account)
+	remove(key interface{}, acc *Account)
+	contains(key interface{}, acc *Account) bool
+	each(f func(key interface{}, id int))
+	keysCount() int
+}
+
+type SecondaryIndex struct {
+	name   string
+	kind   string
+	fields []string
+	keys   func(acc *Account) []interface{}
+	store  indexStore
+}
+
+var secondaryIndexes = []*SecondaryIndex{
+	{name: "country", kind: "postings", store: postingStore{countryIndex}, fields: []string{"country"}, keys: func(acc *Account) []interface{} {
+		return nonEmptyKey(acc.Country)
+	}},
+	{name: "city", kind: "postings", store: postingStore{cityIndex}, fields: []string{"city"}, keys: func(acc *Account) []interface{} {
+		return nonEmptyKey(acc.City)
+	}},
+	{name: "birth_year", kind: "postings", store: postingStore{birthYearIndex}, fields: []string{"birth"}, keys: func(acc *Account) []interface{} {
+		return positiveKey(acc.birthYear)
+	}},
+	{name: "fname", kind: "postings", store: postingStore{fnameIndex}, fields: []string{"fname"}, keys: func(acc *Account) []interface{} {
+		return nonEmptyKey(acc.Fname)
+	}},
+	{name: "sname", kind: "postings", store: postingStore{snameIndex}, fields: []string{"sname"}, keys: func(acc *Account) []interface{} {
+		return nonEmptyKey(acc.Sname)
+	}},
+	{name: "interests", kind: "postings", store: postingStore{interestsIndex}, fields: []string{"interests"}, keys: func(acc *Account) []interface{} {
+		keys := make([]interface{}, 0, len(acc.interestsMap))
+		for interest := range acc.interestsMap {
+			keys = append(keys, interest)
+		}
+		return keys
+	}},
+	{name: "likee", kind: "reverse_likes", store: reverseLikeStore{likeeIndex}, fields: []string{"likes"}, keys: func(acc *Account) []interface{} {
+		keys := make([]interface{}, 0, len(acc.likes))
+		for _, like := range acc.likes {
+			keys = append(keys, reverseLikeKey{int(like.ID), int(like.Ts)})
+		}
+		return keys
+	}},
+	{name: "email_domain", kind: "postings", store: postingStore{emailDomainIndex}, fields: []string{"email"}, keys: func(acc *Account) []interface{} {
+		return nonEmptyKey(acc.emailDomain)
+	}},
+	{name: "phone_code", kind: "postings", store: postingStore{phoneCodeIndex}, fields: []string{"phone"}, keys: func(acc *Account) []interface{} {
+		return positiveKey(acc.phoneCode)
+	}},
+	{name: "joined_year", kind: "postings", store: postingStore{joinedYearIndex}, fields: []string{"joined"}, keys: func(acc *Account) []interface{} {
+		return positiveKey(acc.joinedYear)
+	}},
+	{name: "city_normalized", kind: "postings", store: postingStore{normalizedCityIndex}, fields: []string{"city"}, keys: func(acc *Account) []interface{} {
+		return nonEmptyKey(foldName(acc.City))
+	}},
+	{name: "fname_normalized", kind: "postings", store: foldedNameStore{postingStore{normalizedFnameIndex}, fnameLengths}, fields: []string{"fname"}, keys: func(acc *Account) []interface{} {
+		return nonEmptyKey(foldName(acc.Fname))
+	}},
+	{name: "sname_normalized", kind: "postings", store: foldedNameStore{postingStore{normalizedSnameIndex}, snameLengths}, fields: []string{"sname"}, keys: func(acc *Account) []interface{} {
+		return nonEmptyKey(foldName(acc.Sname))
+	}},
+	{name: "email", kind: "ordered", store: orderedStore{emailIndex}, fields: []string{"email"}, keys: func(acc *Account) []interface{} {
+		return nonEmptyKey(acc.Email)
+	}},
+	{name: "phone", kind: "unique", store: uniqueStore{phoneIndex}, fields: []string{"phone"}, keys: func(acc *Account) []interface{} {
+		return nonEmptyKey(acc.Phone)
+	}},
+	{name: "birth", kind: "ordered", store: orderedStore{birthIndex}, fields: []string{"birth"}, keys: func(acc *Account) []interface{} {
+		return []interface{}{timestampKey{acc.Birth, acc.ID}}
+	}},
+	{name: "joined", kind: "ordered", store: orderedStore{joinedIndex}, fields: []string{"joined"}, keys: func(acc *Account) []interface{} {
+		return []interface{}{timestampKey{acc.Joined, acc.ID}}
+	}},
+	{name: "fname_prefix", kind: "trie", store: trieStore{fnameTrie}, fields: []string{"fname"}, keys: func(acc *Account) []interface{} {
+		return nonEmptyKey(acc.Fname)
+	}},
+	{name: "sname_prefix", kind: "trie", store: trieStore{snameTrie}, fields: []string{"sname"}, keys: func(acc *Account) []interface{} {
+		return nonEmptyKey(acc.Sname)
+	}},
+	{name: "premium", kind: "premium", store: premiumIndex, fields: []string{"premium"}, keys: func(acc *Account) []interface{} {
+		if len(acc.Premium) == 0 {
+			return nil
+		}
+		return []interface{}{premiumPeriod{acc.Premium["start"], acc.Premium["finish"]}}
+	}},
+	{name: "sex_bitmap", kind: "bitmap", store: bitmapStore{sexBitmap}, fields: []string{"sex"}, keys: func(acc *Account) []interface{} {
+		return nonEmptyKey(acc.Sex)
+	}},
+	{name: "status_bitmap", kind: "bitmap", store: bitmapStore{statusBitmap}, fields: []string{"status"}, keys: func(acc *Account) []interface{} {
+		return nonEmptyKey(acc.Status)
+	}},
+	{name: "not_null_bitmap", kind: "bitmap", store: bitmapStore{notNullBitmap}, fields: []string{"fname", "sname", "phone", "country", "city", "premium"}, keys: func(acc *Account) []interface{} {
+		var keys []interface{}
+		for field, value := range map[string]string{
+			"fname": acc.Fname, "sname": acc.Sname, "phone": acc.Phone, "country": acc.Country, "city": acc.City,
+		} {
+			if value != "" {
+				keys = append(keys, field)
+			}
+		}
+		if len(acc.Premium) > 0 {
+			keys = append(keys, "premium")
+		}
+		return keys
+	}},
+	{name: "flags_bitmap", kind: "bitmap", store: bitmapStore{flagsBitmap}, fields: []string{"premium"}, keys: func(acc *Account) []interface{} {
+		keys := []interface{}{bitmapAll}
+		if acc.hasActivePremium(now) {
+			keys = append(keys, bitmapPremiumNow)
+		}
+		return keys
+	}},
+}
+
+func lookupIndex(name string) *SecondaryIndex {
+	for _, index := range secondaryIndexes {
+		if index.name == name {
+			return index
+		}
+	}
+
+	return nil
+}
+
+func nonEmptyKey(value string) []interface{} {
+	if value == "" {
+		return nil
+	}
+
+	return []interface{}{value}
+}
+
+func positiveKey(value int) []interface{} {
+	if value <= 0 {
+		return nil
+	}
+
+	return []interface{}{value}
+}
+
+func indexAccount(acc *Account) {
+	for _, index := range secondaryIndexes {
+		for _, key := range index.keys(acc) {
+			index.store.put(key, acc)
+		}
+	}
+}
+
+func unindexAccount(acc *Account) {
+	for _, index := range secondaryIndexes {
+		for _, key := range index.keys(acc) {
+			index.store.remove(key, acc)
+		}
+	}
+}
+
+// dependsOn checks that keys of the index are extracted from any of fields
+func (index *SecondaryIndex) dependsOn(fields []string) bool {
+	for _, field := range index.fields {
+		if containsString(fields, field) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reindexAccount applies mutate which changes fields and moves the account between keys
+// of indexes which depend on them
+func reindexAccount(acc *Account, fields []string, mutate func()) {
+	var changed []*SecondaryIndex
+	for _, index := range secondaryIndexes {
+		if index.dependsOn(fields) {
+			changed = append(changed, index)
+		}
+	}
+
+	oldKeys := make([][]interface{}, len(changed))
+	for i, index := range changed {
+		oldKeys[i] = index.keys(acc)
+	}
+
+	mutate()
+
+	for i, index := range changed {
+		newKeys := index.keys(acc)
+
+		newSet := make(map[interface{}]struct{}, len(newKeys))
+		for _, key := range newKeys {
+			newSet[key] = struct{}{}
+		}
+		oldSet := make(map[interface{}]struct{}, len(oldKeys[i]))
+		for _, key := range oldKeys[i] {
+			oldSet[key] = struct{}{}
+			if _, ok := newSet[key]; !ok {
+				index.store.remove(key, acc)
+			}
+		}
+		for _, key := range newKeys {
+			if _, ok := oldSet[key]; !ok {
+				index.store.put(key, acc)
+			}
+		}
+	}
+}
+
+// accountId of a stored value, -1 when the value is not an account
+func accountId(value interface{}) int {
+	if acc, ok := value.(*Account); ok {
+		return acc.ID
+	}
+
+	return -1
+}
+
+// SafeIndex of key -> treemap(id -> account)
+type postingStore struct {
+	index *SafeIndex
+}
+
+func (s postingStore) put(key interface{}, acc *Account) {
+	if !s.index.Exists(key) {
+		s.index.Update(key, treemap.NewWith(inverseIntComparator))
+	}
+	s.index.Get(key).(*treemap.Map).Put(acc.ID, acc)
+}
+
+func (s postingStore) remove(key interface{}, acc *Account) {
+	if s.index.Exists(key) {
+		s.index.Get(key).(*treemap.Map).Remove(acc.ID)
+	}
+}
+
+func (s postingStore) contains(key interface{}, acc *Account) bool {
+	if !s.index.Exists(key) {
+		return false
+	}
+	value, ok := s.index.Get(key).(*treemap.Map).Get(acc.ID)
+
+	return ok && value == acc
+}
+
+func (s postingStore) each(f func(key interface{}, id int)) {
+	s.index.Each(func(key interface{}, value interface{}) {
+		for _, id := range value.(*treemap.Map).Keys() {
+			f(key, id.(int))
+		}
+	})
+}
+
+func (s postingStore) keysCount() int {
+	return s.index.Size()
+}
+
+// SafeIndex of unique key -> account
+type uniqueStore struct {
+	index *SafeIndex
+}
+
+func (s uniqueStore) put(key interface{}, acc *Account) {
+	s.index.Update(key, acc)
+}
+
+func (s uniqueStore) remove(key interface{}, acc *Account) {
+	s.index.Delete(key)
+}
+
+func (s uniqueStore) contains(key interface{}, acc *Account) bool {
+	return s.index.Get(key) == acc
+}
+
+func (s uniqueStore) each(f func(key interface{}, id int)) {
+	s.index.Each(func(key interface{}, value interface{}) {
+		f(key, accountId(value))
+	})
+}
+
+func (s uniqueStore) keysCount() int {
+	return s.index.Size()
+}
+
+type orderedStore struct {
+	index *OrderedIndex
+}
+
+func (s orderedStore) put(key interface{}, acc *Account) {
+	s.index.Update(key, acc)
+}
+
+func (s orderedStore) remove(key interface{}, acc *Account) {
+	s.index.Delete(key)
+}
+
+func (s orderedStore) contains(key interface{}, acc *Account) bool {
+	return s.index.Get(key) == acc
+}
+
+func (s orderedStore) each(f func(key interface{}, id int)) {
+	s.index.Range(nil, nil, func(key, value interface{}) bool {
+		f(key, accountId(value))
+		return true
+	})
+}
+
+func (s orderedStore) keysCount() int {
+	return s.index.Size()
+}
+
+type bitmapStore struct {
+	index *BitmapIndex
+}
+
+func (s bitmapStore) put(key interface{}, acc *Account) {
+	s.index.Add(key, acc.ID)
+}
+
+func (s bitmapStore) remove(key interface{}, acc *Account) {
+	s.index.Remove(key, acc.ID)
+}
+
+func (s bitmapStore) contains(key interface{}, acc *Account) bool {
+	return s.index.Contains(key, acc.ID)
+}
+
+func (s bitmapStore) each(f func(key interface{}, id int)) {
+	s.index.Each(f)
+}
+
+func (s bitmapStore) sizeInBytes() int {
+	return s.index.SizeInBytes()
+}
+
+func (s bitmapStore) keysCount() int {
+	return s.index.Size()
+}
+
+// foldedNameStore is a posting store which also keeps distinct names by length for like filters
+type foldedNameStore struct {
+	postingStore
+	lengths *NameLengthIndex
+}
+
+func (s foldedNameStore) put(key interface{}, acc *Account) {
+	s.postingStore.put(key, acc)
+	s.lengths.Add(key.(string))
+}
+
+func (s foldedNameStore) remove(key interface{}, acc *Account) {
+	s.postingStore.remove(key, acc)
+	if postings, ok := s.index.Get(key).(*treemap.Map); !ok || postings.Empty() {
+		s.lengths.Remove(key.(string))
+	}
+}
+
+type trieStore struct {
+	trie *PrefixTrie
+}
+
+func (s trieStore) put(key interface{}, acc *Account) {
+	s.trie.Add(key.(string), acc.ID)
+}
+
+func (s trieStore) remove(key interface{}, acc *Account) {
+	s.trie.Remove(key.(string), acc.ID)
+}
+
+func (s trieStore) contains(key interface{}, acc *Account) bool {
+	return s.trie.Contains(key.(string), acc.ID)
+}
+
+func (s trieStore) each(f func(key interface{}, id int)) {
+	s.trie.Each(func(name string, id int) {
+		f(name, id)
+	})
+}
+
+func (s trieStore) keysCount() int {
+	return s.trie.Size()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/emirpasic/gods/maps/treemap"
+)
+
+func TestReindexAccount(t *testing.T) {
+	acc := &Account{ID: 990000001, Email: "registry@test.local"}
+	acc.interestsMap = map[string]struct{}{"registry_a": {}, "registry_b": {}}
+	indexAccount(acc)
+	defer unindexAccount(acc)
+
+	acc.Update(map[string]interface{}{
+		"interests": []interface{}{"registry_b", "registry_c"},
+		"email":     "registry@moved.local",
+	})
+
+	if _, found := interestsIndex.Get("registry_a").(*treemap.Map).Get(acc.ID); found {
+		t.Error("account is still indexed by removed interest")
+	}
+	for _, interest := range []string{"registry_b", "registry_c"} {
+		if _, found := interestsIndex.Get(interest).(*treemap.Map).Get(acc.ID); !found {
+			t.Error("account is not indexed by", interest)
+		}
+	}
+
+	if emailIndex.Exists("registry@test.local") || !emailIndex.Exists("registry@moved.local") {
+		t.Error("email index was not updated")
+	}
+	if _, found := emailDomainIndex.Get("moved.local").(*treemap.Map).Get(acc.ID); !found {
+		t.Error("email domain index was not updated")
+	}
+}
+
+func TestReindexAccountChangedFields(t *testing.T) {
+	for _, index := range secondaryIndexes {
+		if len(index.fields) == 0 {
+			t.Error("index doesn't declare fields:", index.name)
+		}
+	}
+
+	calls := map[string]int{}
+	spy := func(name string, fields ...string) *SecondaryIndex {
+		return &SecondaryIndex{name: name, fields: fields, store: postingStore{NewSafeIndex()}, keys: func(acc *Account) []interface{} {
+			calls[name]++
+			return nonEmptyKey(acc.Status)
+		}}
+	}
+	indexes := secondaryIndexes
+	defer func() { secondaryIndexes = indexes }()
+	secondaryIndexes = []*SecondaryIndex{spy("status", "status"), spy("likes", "likes")}
+
+	acc := &Account{ID: 990000002}
+	acc.Update(map[string]interface{}{"status": "свободны"})
+	reindexAccount(acc, []string{"likes"}, func() {
+		acc.AppendLike(990000003, 1500000000)
+	})
+
+	if calls["status"] != 2 || calls["likes"] != 2 {
+		t.Error("unexpected key extractions:", calls)
+	}
+}
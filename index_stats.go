@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+)
+
+const indexStatsTopKeys = 5
+
+// rough memory used by one entry (key -> id) and by one key of the index, per kind of store
+var (
+	indexEntryBytes = map[string]int{"postings": 64, "unique": 48, "ordered": 64, "trie": 16, "premium": 128, "reverse_likes": 8}
+	indexKeyBytes   = map[string]int{"postings": 96, "trie": 64, "reverse_likes": 48}
+)
+
+// kinds with one distinct key per account, their keys and postings say nothing, so only entries are counted
+var entryOnlyKinds = map[string]bool{"ordered": true, "premium": true, "unique": true}
+
+// stores which know their memory usage better than estimations by kind
+type sizeEstimator interface {
+	sizeInBytes() int
+}
+
+// keys which are counted in stats by a part of them
+type groupedKey interface {
+	groupKey() interface{}
+}
+
+type IndexStats struct {
+	Name           string     `json:"name"`
+	Kind           string     `json:"kind"`
+	Keys           int        `json:"keys,omitempty"`
+	Entries        int        `json:"entries"`
+	MinPosting     int        `json:"min_posting,omitempty"`
+	MaxPosting     int        `json:"max_posting,omitempty"`
+	AvgPosting     float64    `json:"avg_posting,omitempty"`
+	TopKeys        []KeyStats `json:"top_keys,omitempty"`
+	EstimatedBytes int        `json:"estimated_bytes"`
+}
+
+type KeyStats struct {
+	Key  string `json:"key"`
+	Size int    `json:"size"`
+}
+
+type StoreStats struct {
+	Accounts int          `json:"accounts"`
+	Likes    int          `json:"likes"`
+	Heap     HeapStats    `json:"heap"`
+	Indexes  []IndexStats `json:"indexes"`
+}
+
+type HeapStats struct {
+	Alloc   uint64 `json:"alloc"`
+	InUse   uint64 `json:"in_use"`
+	Objects uint64 `json:"objects"`
+	Sys     uint64 `json:"sys"`
+	NumGC   uint32 `json:"num_gc"`
+}
+
+func collectStats() StoreStats {
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+
+	stats := StoreStats{Accounts: accountIndex.Size()}
+
+	it := accountIndex.Iterator()
+	for it.Next() {
+		stats.Likes += it.Value().(*Account).likes.Count()
+	}
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	stats.Heap = HeapStats{memStats.HeapAlloc, memStats.HeapInuse, memStats.HeapObjects, memStats.Sys, memStats.NumGC}
+
+	for _, index := range secondaryIndexes {
+		stats.Indexes = append(stats.Indexes, collectIndexStats(index))
+	}
+
+	return stats
+}
+
+func collectIndexStats(index *SecondaryIndex) IndexStats {
+	if entryOnlyKinds[index.kind] {
+		stats := IndexStats{Name: index.name, Kind: index.kind}
+		index.store.each(func(key interface{}, id int) {
+			stats.Entries++
+		})
+		stats.EstimatedBytes = estimatedBytes(index, stats)
+		return stats
+	}
+
+	postings := make(map[interface{}]int)
+	index.store.each(func(key interface{}, id int) {
+		if grouped, ok := key.(groupedKey); ok {
+			key = grouped.groupKey()
+		}
+		postings[key]++
+	})
+
+	stats := IndexStats{Name: index.name, Kind: index.kind, Keys: len(postings)}
+	keys := make([]KeyStats, 0, len(postings))
+	for key, size := range postings {
+		stats.Entries += size
+		if stats.MinPosting == 0 || size < stats.MinPosting {
+			stats.MinPosting = size
+		}
+		if size > stats.MaxPosting {
+			stats.MaxPosting = size
+		}
+		keys = append(keys, KeyStats{fmt.Sprint(key), size})
+	}
+	if stats.Keys > 0 {
+		stats.AvgPosting = float64(stats.Entries) / float64(stats.Keys)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Size != keys[j].Size {
+			return keys[i].Size > keys[j].Size
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if len(keys) > indexStatsTopKeys {
+		keys = keys[:indexStatsTopKeys]
+	}
+	stats.TopKeys = keys
+
+	stats.EstimatedBytes = estimatedBytes(index, stats)
+
+	return stats
+}
+
+func estimatedBytes(index *SecondaryIndex, stats IndexStats) int {
+	if estimator, ok := index.store.(sizeEstimator); ok {
+		return estimator.sizeInBytes()
+	}
+
+	return stats.Entries*indexEntryBytes[index.kind] + stats.Keys*indexKeyBytes[index.kind]
+}
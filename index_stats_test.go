@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCollectIndexStats(t *testing.T) {
+	index := NewBitmapIndex()
+	index.Add("a", 1)
+	index.Add("a", 2)
+	index.Add("a", 3)
+	index.Add("b", 4)
+
+	stats := collectIndexStats(&SecondaryIndex{name: "test", kind: "bitmap", store: bitmapStore{index}})
+	if stats.Keys != 2 || stats.Entries != 4 || stats.MinPosting != 1 || stats.MaxPosting != 3 || stats.AvgPosting != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if len(stats.TopKeys) != 2 || stats.TopKeys[0] != (KeyStats{"a", 3}) {
+		t.Errorf("unexpected top keys %+v", stats.TopKeys)
+	}
+	if stats.EstimatedBytes <= 0 {
+		t.Error("size is not estimated")
+	}
+}
+
+func TestCollectIndexStatsEntryOnly(t *testing.T) {
+	index := NewOrderedIndex(timestampKeyComparator)
+	for id := 1; id <= 3; id++ {
+		index.Update(timestampKey{id * 100, id}, &Account{ID: id})
+	}
+
+	stats := collectIndexStats(&SecondaryIndex{name: "test", kind: "ordered", store: orderedStore{index}})
+	if stats.Entries != 3 || stats.Keys != 0 || stats.MaxPosting != 0 || stats.AvgPosting != 0 || len(stats.TopKeys) != 0 {
+		t.Errorf("unexpected ordered stats %+v", stats)
+	}
+	if stats.EstimatedBytes != 3*indexEntryBytes["ordered"] {
+		t.Error("unexpected size", stats.EstimatedBytes)
+	}
+}
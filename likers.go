@@ -0,0 +1,169 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// LikerEntry is a liker with the average timestamp of its likes to the likee
+type LikerEntry struct {
+	ID int32
+	Ts int32
+}
+
+// Likers are sorted by liker id in descending order, as accountIndex is
+type Likers []LikerEntry
+
+func (likers Likers) search(likerId int) (int, bool) {
+	idx := sort.Search(len(likers), func(i int) bool { return int(likers[i].ID) <= likerId })
+
+	return idx, idx < len(likers) && int(likers[idx].ID) == likerId
+}
+
+// ReverseLikeIndex keeps likee -> likers, so similarity could be calculated without reading likers' accounts
+type ReverseLikeIndex struct {
+	v   map[int]Likers
+	mux sync.RWMutex
+}
+
+func NewReverseLikeIndex() *ReverseLikeIndex {
+	return &ReverseLikeIndex{v: make(map[int]Likers)}
+}
+
+func (idx *ReverseLikeIndex) Put(likeeId int, likerId int, ts int) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	likers := idx.v[likeeId]
+	i, found := likers.search(likerId)
+	if !found {
+		likers = append(likers, LikerEntry{})
+		copy(likers[i+1:], likers[i:])
+		idx.v[likeeId] = likers
+	}
+	likers[i] = LikerEntry{int32(likerId), int32(ts)}
+}
+
+func (idx *ReverseLikeIndex) Remove(likeeId int, likerId int) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	likers := idx.v[likeeId]
+	i, found := likers.search(likerId)
+	if !found {
+		return
+	}
+	if len(likers) == 1 {
+		delete(idx.v, likeeId)
+		return
+	}
+	idx.v[likeeId] = append(likers[:i], likers[i+1:]...)
+}
+
+// Find returns the average timestamp of liker's likes to likee
+func (idx *ReverseLikeIndex) Find(likeeId int, likerId int) (int, bool) {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	likers := idx.v[likeeId]
+	if i, found := likers.search(likerId); found {
+		return int(likers[i].Ts), true
+	}
+
+	return 0, false
+}
+
+// Each calls f for likers of likee under the lock, f must not modify the index
+func (idx *ReverseLikeIndex) Each(likeeId int, f func(liker LikerEntry)) {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	for _, liker := range idx.v[likeeId] {
+		f(liker)
+	}
+}
+
+// Likers returns ids of accounts which liked every likee
+func (idx *ReverseLikeIndex) Likers(likeeIds ...int) *Bitmap {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	var result *Bitmap
+	for _, likeeId := range likeeIds {
+		likers := NewBitmap()
+		for _, liker := range idx.v[likeeId] {
+			likers.Add(int(liker.ID))
+		}
+		if result == nil {
+			result = likers
+		} else {
+			result = result.And(likers)
+		}
+		if result.IsEmpty() {
+			break
+		}
+	}
+	if result == nil {
+		return NewBitmap()
+	}
+
+	return result
+}
+
+func (idx *ReverseLikeIndex) Count(likeeId int) int {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	return len(idx.v[likeeId])
+}
+
+func (idx *ReverseLikeIndex) Size() int {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	return len(idx.v)
+}
+
+// reverseLikeKey is used by the registry, the timestamp is a part of the key,
+// so changed averages are moved as any other changed key
+type reverseLikeKey struct {
+	likee int
+	ts    int
+}
+
+func (key reverseLikeKey) groupKey() interface{} {
+	return key.likee
+}
+
+type reverseLikeStore struct {
+	index *ReverseLikeIndex
+}
+
+func (s reverseLikeStore) put(key interface{}, acc *Account) {
+	s.index.Put(key.(reverseLikeKey).likee, acc.ID, key.(reverseLikeKey).ts)
+}
+
+func (s reverseLikeStore) remove(key interface{}, acc *Account) {
+	s.index.Remove(key.(reverseLikeKey).likee, acc.ID)
+}
+
+func (s reverseLikeStore) contains(key interface{}, acc *Account) bool {
+	ts, found := s.index.Find(key.(reverseLikeKey).likee, acc.ID)
+
+	return found && ts == key.(reverseLikeKey).ts
+}
+
+func (s reverseLikeStore) each(f func(key interface{}, id int)) {
+	s.index.mux.RLock()
+	defer s.index.mux.RUnlock()
+
+	for likeeId, likers := range s.index.v {
+		for _, liker := range likers {
+			f(reverseLikeKey{likeeId, int(liker.Ts)}, int(liker.ID))
+		}
+	}
+}
+
+func (s reverseLikeStore) keysCount() int {
+	return s.index.Size()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestReverseLikeIndex(t *testing.T) {
+	index := NewReverseLikeIndex()
+	index.Put(1, 10, 100)
+	index.Put(1, 30, 300)
+	index.Put(1, 20, 200)
+	index.Put(2, 20, 250)
+
+	var ids []int32
+	index.Each(1, func(liker LikerEntry) {
+		ids = append(ids, liker.ID)
+	})
+	if len(ids) != 3 || ids[0] != 30 || ids[2] != 10 {
+		t.Errorf("likers are not sorted by descending id: %v", ids)
+	}
+
+	index.Put(1, 20, 210)
+	if ts, found := index.Find(1, 20); !found || ts != 210 {
+		t.Errorf("timestamp was not updated: %d", ts)
+	}
+
+	if likers := index.Likers(1, 2).ToSlice(); len(likers) != 1 || likers[0] != 20 {
+		t.Errorf("unexpected common likers %v", likers)
+	}
+
+	index.Remove(2, 20)
+	if index.Count(2) != 0 || index.Size() != 1 {
+		t.Error("empty likee was not removed")
+	}
+}
+
+func TestReverseLikeIndexFollowsAverage(t *testing.T) {
+	acc := &Account{ID: 990000003}
+	acc.likes.Append(990000004, 100)
+	indexAccount(acc)
+	defer unindexAccount(acc)
+
+	reindexAccount(acc, []string{"likes"}, func() {
+		acc.AppendLike(990000004, 200)
+	})
+
+	if ts, found := likeeIndex.Find(990000004, acc.ID); !found || ts != 150 {
+		t.Errorf("expected averaged timestamp 150, got %d", ts)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sort"
+)
+
+// LikeEntry is an aggregate of all likes from one account to another,
+// Ts is the average timestamp which is kept up to date by Append
+type LikeEntry struct {
+	TsSum int64
+	ID    int32
+	Ts    int32
+	Count int32
+}
+
+// Likes are sorted by likee id
+type Likes []LikeEntry
+
+func (likes Likes) search(likeeId int) (int, bool) {
+	idx := sort.Search(len(likes), func(i int) bool { return int(likes[i].ID) >= likeeId })
+
+	return idx, idx < len(likes) && int(likes[idx].ID) == likeeId
+}
+
+func (likes Likes) Find(likeeId int) (LikeEntry, bool) {
+	if idx, found := likes.search(likeeId); found {
+		return likes[idx], true
+	}
+
+	return LikeEntry{}, false
+}
+
+func (likes Likes) Contains(likeeId int) bool {
+	_, found := likes.search(likeeId)
+
+	return found
+}
+
+// Distinct is the amount of liked accounts, it is likes_count of the account in filters and responses
+func (likes Likes) Distinct() int {
+	return len(likes)
+}
+
+// Count of likes including repeated ones
+func (likes Likes) Count() int {
+	total := 0
+	for _, like := range likes {
+		total += int(like.Count)
+	}
+
+	return total
+}
+
+func (likes *Likes) Append(likeeId int, ts int) {
+	idx, found := likes.search(likeeId)
+	if !found {
+		*likes = append(*likes, LikeEntry{})
+		copy((*likes)[idx+1:], (*likes)[idx:])
+		(*likes)[idx] = LikeEntry{ID: int32(likeeId)}
+	}
+
+	like := &(*likes)[idx]
+	like.TsSum += int64(ts)
+	like.Count++
+	like.Ts = int32(like.TsSum / int64(like.Count))
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLikesAppend(t *testing.T) {
+	var likes Likes
+	likes.Append(30, 100)
+	likes.Append(10, 200)
+	likes.Append(20, 300)
+	likes.Append(10, 301)
+
+	for i, id := range []int32{10, 20, 30} {
+		if likes[i].ID != id {
+			t.Fatalf("likes are not sorted: %+v", likes)
+		}
+	}
+
+	like, found := likes.Find(10)
+	if !found || like.Count != 2 || like.Ts != 250 {
+		t.Errorf("unexpected aggregated like %+v", like)
+	}
+	if likes.Contains(40) || !likes.Contains(30) {
+		t.Error("unexpected Contains result")
+	}
+	if likes.Count() != 4 {
+		t.Errorf("expected 4 likes, got %d", likes.Count())
+	}
+}
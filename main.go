@@ -29,11 +29,18 @@ var (
 	log = logrus.New()
 
 	isDebugMode = os.Getenv("DEBUG")
+
+	// `now` follows the wall clock instead of options.txt
+	isRealtimeClock = os.Getenv("REALTIME_CLOCK")
+
+	// "check" logs index mismatches after the data is loaded, "repair" also fixes them
+	checkIndexesMode = os.Getenv("CHECK_INDEXES")
 )
 
 func main() {
 	if isDebugMode != "" {
 		log.Println("Debug-mode enabled")
+		registerDebugHandlers()
 		go func() {
 			log.Println(http.ListenAndServe("localhost:6060", nil))
 		}()
@@ -49,6 +56,24 @@ func main() {
 
 	log.Println("Data has been parsed completely")
 
+	startWriter()
+
+	if isRealtimeClock != "" {
+		log.Println("Realtime clock enabled")
+		setNow(time.Now().Unix())
+		go runPremiumSweeper(time.Second)
+	}
+
+	if checkIndexesMode != "" {
+		for _, result := range checkIndexes(checkIndexesMode == "repair") {
+			if !result.IsConsistent() {
+				log.Printf("Index %s is inconsistent: %d missing, %d stale, repaired: %t %v",
+					result.Name, result.Missing, result.Stale, result.Repaired, result.Examples)
+			}
+		}
+		log.Println("Indexes have been checked")
+	}
+
 	runtime.GC()
 	log.Println("GC has been finished")
 
@@ -60,70 +85,95 @@ func main() {
 /*
 GET:
 /accounts/filter/
+/accounts/filter/count/
 /accounts/group/
 /accounts/<id>/recommend/
 /accounts/<id>/suggest/
 
 POST:
 /accounts/new/
+/accounts/query/
 /accounts/<id>/
 /accounts/likes/
 */
 
 func requestHandler(ctx *fasthttp.RequestCtx) {
-	path := ctx.Path()
+	if ctx.IsGet() {
+		readLocked(func() {
+			routeGetRequest(ctx)
+		})
+		return
+	}
 
-	isGetRequest := ctx.IsGet()
-	isPostRequest := ctx.IsPost()
+	if ctx.IsPost() {
+		// validation reads the store, the write itself is applied after the read lock is released
+		readLocked(func() {
+			routePostRequest(ctx)
+		})
+		commitWrite(ctx)
+	}
+}
 
+func routeGetRequest(ctx *fasthttp.RequestCtx) {
+	path := ctx.Path()
 	pathLen := len(path)
 
-	if isGetRequest {
-		// /accounts/group/
-		if pathLen == 16 && path[14] == 'p' {
-			groupHandler(ctx)
-			return
-		}
-		// /accounts/filter/
-		if pathLen == 17 && path[15] == 'r' {
-			filterHandler(ctx)
-			return
-		}
-		// /accounts/<id>/suggest/
-		if pathLen >= 20 && pathLen <= 30 && path[pathLen-2] == 't' {
-			suggestHandler(ctx, parseAccountId(path))
-			return
-		}
-		// /accounts/<id>/recommend/
-		if pathLen >= 21 && pathLen <= 31 && path[pathLen-2] == 'd' {
-			recommendHandler(ctx, parseAccountId(path))
-			return
-		}
-
-		// 404
-		ctx.Error("{}", 404)
+	// /accounts/group/
+	if pathLen == 16 && path[14] == 'p' {
+		groupHandler(ctx)
+		return
+	}
+	// /accounts/filter/
+	if pathLen == 17 && path[15] == 'r' {
+		filterHandler(ctx)
+		return
+	}
+	// /accounts/filter/count/
+	if pathLen == 23 && string(path) == "/accounts/filter/count/" {
+		filterCountHandler(ctx)
+		return
+	}
+	// /accounts/<id>/suggest/
+	if pathLen >= 20 && pathLen <= 30 && path[pathLen-2] == 't' {
+		suggestHandler(ctx, parseAccountId(path))
+		return
+	}
+	// /accounts/<id>/recommend/
+	if pathLen >= 21 && pathLen <= 31 && path[pathLen-2] == 'd' {
+		recommendHandler(ctx, parseAccountId(path))
 		return
 	}
 
-	if isPostRequest {
-		// /accounts/new/
-		if pathLen == 14 && path[pathLen-2] == 'w' {
-			createUserHandler(ctx)
-			return
-		}
-		// /accounts/likes/
-		if pathLen == 16 && path[pathLen-2] == 's' {
-			updateLikesHandler(ctx)
-			return
-		}
-		// /accounts/<id>/
-		if pathLen >= 12 && pathLen <= 21 && path[8] == 's' {
-			updateUserHandler(ctx, parseAccountId(path))
-			return
-		}
-		// 404
-		ctx.Error("{}", 404)
+	// 404
+	ctx.Error("{}", 404)
+}
+
+func routePostRequest(ctx *fasthttp.RequestCtx) {
+	path := ctx.Path()
+	pathLen := len(path)
+
+	// /accounts/new/
+	if pathLen == 14 && path[pathLen-2] == 'w' {
+		createUserHandler(ctx)
+		return
 	}
+	// /accounts/query/
+	if pathLen == 16 && path[pathLen-2] == 'y' {
+		queryHandler(ctx)
+		return
+	}
+	// /accounts/likes/
+	if pathLen == 16 && path[pathLen-2] == 's' {
+		updateLikesHandler(ctx)
+		return
+	}
+	// /accounts/<id>/
+	if pathLen >= 12 && pathLen <= 21 && path[8] == 's' {
+		updateUserHandler(ctx, parseAccountId(path))
+		return
+	}
+	// 404
+	ctx.Error("{}", 404)
 }
 
 func parseAccountId(path []byte) int {
@@ -170,16 +220,19 @@ func parseAccountsMap(fileBytes []byte) {
 	var accounts jsonKey
 	json.Unmarshal(fileBytes, &accounts)
 
-	for _, account := range accounts.Accounts {
-		NewAccount(account)
-	}
+	writeLocked(func() {
+		for _, account := range accounts.Accounts {
+			NewAccount(account)
+		}
+	})
 }
 
 func parseOptions(filename string) {
 	if file, err := os.OpenFile(filename, os.O_RDONLY, 0644); err == nil {
 		reader := bufio.NewReader(file)
 		if line, _, err := reader.ReadLine(); err == nil {
-			now, _ = strconv.ParseInt(string(line), 10, 32)
+			value, _ := strconv.ParseInt(string(line), 10, 32)
+			setNow(value)
 			log.Println("`Now` was updated from options.txt", now)
 		}
 	}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/emirpasic/gods/maps/treemap"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ё is written as е in most names, so they are matched as the same letter
+var yoReplacer = strings.NewReplacer("ё", "е")
+
+// foldName is a key of case-insensitive indexes: the value is normalized to NFKC, so composed and decomposed
+// letters and compatibility forms are equal, then it is case folded
+func foldName(value string) string {
+	return yoReplacer.Replace(cases.Fold().String(norm.NFKC.String(value)))
+}
+
+// likeDistance is the max amount of typos in a name, short names allow only one
+func likeDistance(value string) int {
+	if utf8.RuneCountInString(value) <= 5 {
+		return 1
+	}
+
+	return 2
+}
+
+// withinDistance checks that Levenshtein distance between a and b is at most max,
+// only the diagonal band of width 2*max+1 is calculated
+func withinDistance(a, b []rune, max int) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	if len(b)-len(a) > max {
+		return false
+	}
+
+	const infinity = 1 << 30
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		from, to := i-max, i+max
+		if from < 1 {
+			from = 1
+		}
+		if to > len(b) {
+			to = len(b)
+		}
+		current[0] = i
+		if from > 1 {
+			current[from-1] = infinity
+		}
+		rowMin := infinity
+		for j := from; j <= to; j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			distance := previous[j-1] + cost
+			if previous[j]+1 < distance {
+				distance = previous[j] + 1
+			}
+			if current[j-1]+1 < distance {
+				distance = current[j-1] + 1
+			}
+			current[j] = distance
+			if distance < rowMin {
+				rowMin = distance
+			}
+		}
+		if to < len(b) {
+			current[to+1] = infinity
+		}
+		if rowMin > max {
+			return false
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)] <= max
+}
+
+// NameLengthIndex keeps distinct folded names by their length in runes, names which are close by
+// edit distance have close lengths, so like candidates are taken only from a few buckets
+type NameLengthIndex struct {
+	v   map[int]map[string]struct{}
+	mux sync.RWMutex
+}
+
+func NewNameLengthIndex() *NameLengthIndex {
+	return &NameLengthIndex{v: map[int]map[string]struct{}{}}
+}
+
+func (idx *NameLengthIndex) Add(name string) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	length := utf8.RuneCountInString(name)
+	if idx.v[length] == nil {
+		idx.v[length] = map[string]struct{}{}
+	}
+	idx.v[length][name] = struct{}{}
+}
+
+func (idx *NameLengthIndex) Remove(name string) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	length := utf8.RuneCountInString(name)
+	delete(idx.v[length], name)
+	if len(idx.v[length]) == 0 {
+		delete(idx.v, length)
+	}
+}
+
+// Within returns a copy of names which lengths differ from length by at most max
+func (idx *NameLengthIndex) Within(length int, max int) []string {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	var names []string
+	for l := length - max; l <= length+max; l++ {
+		for name := range idx.v[l] {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// likeBitmap collects accounts of folded names which are close to the value, distinct names of
+// the length band are compared instead of accounts and no index is locked while they are compared
+func likeBitmap(index *SafeIndex, lengths *NameLengthIndex, value string) *Bitmap {
+	target := []rune(foldName(value))
+	max := likeDistance(value)
+
+	result := NewBitmap()
+	for _, name := range lengths.Within(len(target), max) {
+		if !withinDistance([]rune(name), target, max) {
+			continue
+		}
+		if postings, ok := index.Get(name).(*treemap.Map); ok {
+			for _, id := range postings.Keys() {
+				result.Add(id.(int))
+			}
+		}
+	}
+
+	return result
+}
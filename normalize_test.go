@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFoldName(t *testing.T) {
+	cases := map[string]string{
+		"Москва":                "москва",
+		"\u0415\u0308лка":       "елка",
+		"ЁЛКА":                  "елка",
+		"Андреи\u0306":          "андрей",
+		"\u0418\u0306ошкар-Ола": "йошкар-ола",
+		"Артём":                 "артем",
+		"Cafe\u0301":            "caf\u00e9",
+		"\uFF21\uFF22":          "ab",
+		"STRAßE":                "strasse",
+	}
+	for value, expected := range cases {
+		if folded := foldName(value); folded != expected {
+			t.Errorf("%q: expected %q, got %q", value, expected, folded)
+		}
+	}
+}
+
+func TestWithinDistance(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		max      int
+		expected bool
+	}{
+		{"анна", "анна", 0, true},
+		{"анна", "ана", 1, true},
+		{"анна", "инна", 1, true},
+		{"анна", "инга", 1, false},
+		{"анна", "инга", 2, true},
+		{"александр", "алекснадр", 1, false},
+		{"александр", "алекснадр", 2, true},
+		{"", "ан", 1, false},
+		{"ан", "", 2, true},
+		{"абвгд", "бвгде", 2, true},
+	}
+	for _, c := range cases {
+		if withinDistance([]rune(c.a), []rune(c.b), c.max) != c.expected {
+			t.Errorf("%s, %s within %d: expected %t", c.a, c.b, c.max, c.expected)
+		}
+	}
+}
+
+func TestLikeBitmap(t *testing.T) {
+	index, lengths := NewSafeIndex(), NewNameLengthIndex()
+	store := foldedNameStore{postingStore{index}, lengths}
+	for id, name := range []string{"анна", "ана", "инна", "аннабелла", "а", "анастасия"} {
+		store.put(name, &Account{ID: id + 1})
+	}
+
+	if ids := likeBitmap(index, lengths, "Анна").ToSlice(); !reflect.DeepEqual(ids, []int{3, 2, 1}) {
+		t.Error("Анна:", ids)
+	}
+	if ids := likeBitmap(index, lengths, "Анастасея").ToSlice(); !reflect.DeepEqual(ids, []int{6}) {
+		t.Error("Анастасея:", ids)
+	}
+
+	// only names of the length band are candidates
+	if names := lengths.Within(4, 1); len(names) != 3 {
+		t.Error("names of 3-5 runes:", names)
+	}
+	store.remove("ана", &Account{ID: 2})
+	if names := lengths.Within(3, 0); len(names) != 0 {
+		t.Error("removed name is still a candidate:", names)
+	}
+	if ids := likeBitmap(index, lengths, "Анна").ToSlice(); !reflect.DeepEqual(ids, []int{3, 1}) {
+		t.Error("Анна after remove:", ids)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"math"
+	"sync"
+
+	"github.com/emirpasic/gods/trees/redblacktree"
+	"github.com/emirpasic/gods/utils"
+)
+
+// OrderedIndex is a SafeIndex replacement for keys which are queried by ranges
+type OrderedIndex struct {
+	tree *redblacktree.Tree
+	mux  sync.RWMutex
+}
+
+func NewOrderedIndex(comparator utils.Comparator) *OrderedIndex {
+	return &OrderedIndex{tree: redblacktree.NewWith(comparator)}
+}
+
+func (idx *OrderedIndex) Exists(key interface{}) bool {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	_, ok := idx.tree.Get(key)
+
+	return ok
+}
+
+func (idx *OrderedIndex) Update(key interface{}, value interface{}) {
+	idx.mux.Lock()
+
+	idx.tree.Put(key, value)
+
+	idx.mux.Unlock()
+}
+
+func (idx *OrderedIndex) Delete(key interface{}) {
+	idx.mux.Lock()
+
+	idx.tree.Remove(key)
+
+	idx.mux.Unlock()
+}
+
+func (idx *OrderedIndex) Get(key interface{}) interface{} {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	value, _ := idx.tree.Get(key)
+
+	return value
+}
+
+func (idx *OrderedIndex) Size() int {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	return idx.tree.Size()
+}
+
+// Range calls f in ascending key order for from < key < to, nil bound means unbounded
+func (idx *OrderedIndex) Range(from, to interface{}, f func(key, value interface{}) bool) {
+	idx.mux.RLock()
+	defer idx.mux.RUnlock()
+
+	walkRange(idx.tree.Root, idx.tree.Comparator, from, to, f)
+}
+
+// RangeBitmap collects ids of accounts in range, returns nil when there are more than limit of them
+func (idx *OrderedIndex) RangeBitmap(from, to interface{}, limit int) *Bitmap {
+	result := NewBitmap()
+	count := 0
+	idx.Range(from, to, func(key, value interface{}) bool {
+		count++
+		if count > limit {
+			return false
+		}
+		result.Add(value.(*Account).ID)
+		return true
+	})
+	if count > limit {
+		return nil
+	}
+
+	return result
+}
+
+func walkRange(node *redblacktree.Node, comparator utils.Comparator, from, to interface{}, f func(key, value interface{}) bool) bool {
+	if node == nil {
+		return true
+	}
+
+	afterFrom := from == nil || comparator(node.Key, from) > 0
+	beforeTo := to == nil || comparator(node.Key, to) < 0
+
+	if afterFrom && !walkRange(node.Left, comparator, from, to, f) {
+		return false
+	}
+	if afterFrom && beforeTo && !f(node.Key, node.Value) {
+		return false
+	}
+	if beforeTo && !walkRange(node.Right, comparator, from, to, f) {
+		return false
+	}
+
+	return true
+}
+
+// timestampKey orders accounts by a timestamp field, id keeps keys unique
+type timestampKey struct {
+	ts int
+	id int
+}
+
+func timestampKeyComparator(a, b interface{}) int {
+	key1 := a.(timestampKey)
+	key2 := b.(timestampKey)
+
+	switch {
+	case key1.ts < key2.ts:
+		return -1
+	case key1.ts > key2.ts:
+		return 1
+	}
+
+	return utils.IntComparator(key1.id, key2.id)
+}
+
+// timestampRange converts optional gt < ts < lt bounds into timestampKey bounds for Range
+func timestampRange(gt, lt *int) (from, to interface{}) {
+	if gt != nil {
+		from = timestampKey{ts: *gt, id: math.MaxInt64}
+	}
+	if lt != nil {
+		to = timestampKey{ts: *lt, id: math.MinInt64}
+	}
+
+	return from, to
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/emirpasic/gods/utils"
+)
+
+func TestOrderedIndexRange(t *testing.T) {
+	idx := NewOrderedIndex(utils.StringComparator)
+	for id, email := range []string{"a@x.ru", "b@x.ru", "c@x.ru", "d@x.ru", "e@x.ru"} {
+		idx.Update(email, &Account{ID: id + 1, Email: email})
+	}
+
+	var keys []string
+	idx.Range("a@x.ru", "e@x.ru", func(key, value interface{}) bool {
+		keys = append(keys, key.(string))
+		return true
+	})
+	if !reflect.DeepEqual(keys, []string{"b@x.ru", "c@x.ru", "d@x.ru"}) {
+		t.Error("bounded range:", keys)
+	}
+
+	if ids := idx.RangeBitmap(nil, "c", 10).ToSlice(); !reflect.DeepEqual(ids, []int{2, 1}) {
+		t.Error("lt range:", ids)
+	}
+	if ids := idx.RangeBitmap("c", nil, 10).ToSlice(); !reflect.DeepEqual(ids, []int{5, 4, 3}) {
+		t.Error("gt range:", ids)
+	}
+	if idx.RangeBitmap(nil, nil, 4) != nil {
+		t.Error("expected nil for non-selective range")
+	}
+}
+
+func TestBirthRangeByAge(t *testing.T) {
+	idx := NewOrderedIndex(timestampKeyComparator)
+	reference := time.Date(2018, 12, 20, 0, 0, 0, 0, time.UTC).Unix()
+	births := map[int]time.Time{
+		1: time.Date(1990, 12, 20, 0, 0, 0, 0, time.UTC), // 28 exactly
+		2: time.Date(1990, 12, 20, 0, 0, 1, 0, time.UTC), // 27
+		3: time.Date(1995, 6, 1, 0, 0, 0, 0, time.UTC),   // 23
+		4: time.Date(1995, 6, 1, 0, 0, 0, 0, time.UTC),   // 23, same timestamp
+		5: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),   // 18
+	}
+	for id, birth := range births {
+		idx.Update(timestampKey{int(birth.Unix()), id}, &Account{ID: id, Birth: int(birth.Unix())})
+	}
+
+	ageGt, ageLt := 22, 28
+	from, to := timestampRange(ageToBirthRange(&ageGt, &ageLt, reference))
+	if ids := idx.RangeBitmap(from, to, 10).ToSlice(); !reflect.DeepEqual(ids, []int{4, 3, 2}) {
+		t.Error("22 < age < 28:", ids)
+	}
+
+	ageGt = 27
+	from, to = timestampRange(ageToBirthRange(&ageGt, nil, reference))
+	if ids := idx.RangeBitmap(from, to, 10).ToSlice(); !reflect.DeepEqual(ids, []int{1}) {
+		t.Error("age > 27:", ids)
+	}
+
+	birthLt := int(births[3].Unix())
+	from, to = timestampRange(nil, &birthLt)
+	if ids := idx.RangeBitmap(from, to, 10).ToSlice(); !reflect.DeepEqual(ids, []int{2, 1}) {
+		t.Error("birth_lt:", ids)
+	}
+}
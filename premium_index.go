@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// PremiumIndex keeps premium periods ordered by start and by finish,
+// so accounts with active premium could be found for any reference time
+type PremiumIndex struct {
+	starts   *OrderedIndex
+	finishes *OrderedIndex
+}
+
+func NewPremiumIndex() *PremiumIndex {
+	return &PremiumIndex{
+		starts:   NewOrderedIndex(timestampKeyComparator),
+		finishes: NewOrderedIndex(timestampKeyComparator),
+	}
+}
+
+type premiumPeriod struct {
+	start  int
+	finish int
+}
+
+func (idx *PremiumIndex) put(key interface{}, acc *Account) {
+	period := key.(premiumPeriod)
+	idx.starts.Update(timestampKey{period.start, acc.ID}, acc)
+	idx.finishes.Update(timestampKey{period.finish, acc.ID}, acc)
+}
+
+func (idx *PremiumIndex) remove(key interface{}, acc *Account) {
+	period := key.(premiumPeriod)
+	idx.starts.Delete(timestampKey{period.start, acc.ID})
+	idx.finishes.Delete(timestampKey{period.finish, acc.ID})
+}
+
+func (idx *PremiumIndex) contains(key interface{}, acc *Account) bool {
+	period := key.(premiumPeriod)
+
+	return idx.starts.Get(timestampKey{period.start, acc.ID}) == acc &&
+		idx.finishes.Get(timestampKey{period.finish, acc.ID}) == acc
+}
+
+// each joins both trees by id, a side which is missing is reported as math.MinInt32
+func (idx *PremiumIndex) each(f func(key interface{}, id int)) {
+	periods := make(map[int]*premiumPeriod)
+	period := func(id int) *premiumPeriod {
+		if _, ok := periods[id]; !ok {
+			periods[id] = &premiumPeriod{math.MinInt32, math.MinInt32}
+		}
+		return periods[id]
+	}
+
+	idx.starts.Range(nil, nil, func(key, value interface{}) bool {
+		period(key.(timestampKey).id).start = key.(timestampKey).ts
+		return true
+	})
+	idx.finishes.Range(nil, nil, func(key, value interface{}) bool {
+		period(key.(timestampKey).id).finish = key.(timestampKey).ts
+		return true
+	})
+
+	for id, period := range periods {
+		f(*period, id)
+	}
+}
+
+func (idx *PremiumIndex) keysCount() int {
+	return idx.starts.Size()
+}
+
+// ActiveAt returns accounts with start <= ts < finish
+func (idx *PremiumIndex) ActiveAt(ts int64) *Bitmap {
+	startedBefore := int(ts) + 1
+	from, to := timestampRange(nil, &startedBefore)
+	started := idx.starts.RangeBitmap(from, to, math.MaxInt64)
+
+	finishedAfter := int(ts)
+	from, to = timestampRange(&finishedAfter, nil)
+	notFinished := idx.finishes.RangeBitmap(from, to, math.MaxInt64)
+
+	return started.And(notFinished)
+}
+
+// ChangedBetween returns accounts which premium started or finished in (from, to]
+func (idx *PremiumIndex) ChangedBetween(from, to int64) []*Account {
+	var changed []*Account
+	collect := func(key, value interface{}) bool {
+		changed = append(changed, value.(*Account))
+		return true
+	}
+
+	gt, lt := int(from), int(to)+1
+	fromKey, toKey := timestampRange(&gt, &lt)
+	idx.starts.Range(fromKey, toKey, collect)
+	idx.finishes.Range(fromKey, toKey, collect)
+
+	return changed
+}
+
+// setNow changes the reference time and rebuilds premium_now bitmap for it
+func setNow(value int64) {
+	writeLocked(func() {
+		resetNow(value)
+	})
+}
+
+func resetNow(value int64) {
+	now = value
+	flagsBitmap.Replace(bitmapPremiumNow, premiumIndex.ActiveAt(value))
+}
+
+// sweepPremiums moves the reference time forward, only accounts which premium
+// started or expired since the previous sweep are revisited
+func sweepPremiums(value int64) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	previous := now
+	if value < previous {
+		resetNow(value)
+		return
+	}
+
+	now = value
+	for _, acc := range premiumIndex.ChangedBetween(previous, value) {
+		updateBitmap(flagsBitmap, bitmapPremiumNow, acc.ID, acc.hasActivePremium(value))
+	}
+}
+
+// runPremiumSweeper is used when the reference time follows the wall clock
+func runPremiumSweeper(interval time.Duration) {
+	for range time.Tick(interval) {
+		sweepPremiums(time.Now().Unix())
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPremiumIndexActiveAt(t *testing.T) {
+	idx := NewPremiumIndex()
+	idx.put(premiumPeriod{100, 200}, &Account{ID: 1})
+	idx.put(premiumPeriod{150, 300}, &Account{ID: 2})
+	idx.put(premiumPeriod{200, 250}, &Account{ID: 3})
+
+	cases := map[int64][]int{
+		99:  {},
+		100: {1},
+		199: {2, 1},
+		200: {3, 2},
+		300: {},
+	}
+	for ts, expected := range cases {
+		if ids := idx.ActiveAt(ts).ToSlice(); !reflect.DeepEqual(ids, expected) {
+			t.Error("active at", ts, "expected", expected, "got", ids)
+		}
+	}
+
+	var changed []int
+	for _, acc := range idx.ChangedBetween(150, 200) {
+		changed = append(changed, acc.ID)
+	}
+	// start of #3 and finish of #1, start of #2 is not included
+	if !reflect.DeepEqual(changed, []int{3, 1}) {
+		t.Error("changed between 150 and 200:", changed)
+	}
+}
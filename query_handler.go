@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+var errInvalidQuery = errors.New("invalid query")
+
+// optionParams are passed at the top level of a query, they aren't predicates
+var optionParams = map[string]struct{}{
+	"query_id": {}, "limit": {}, sortParam: {}, fieldsParam: {}, cursorParam: {}, countParam: {},
+}
+
+// queryHandler accepts a boolean expression over filter predicates:
+//
+//	{"where": {"and": [{"country_eq": "Испания"}, {"or": [{"city_eq": "Мадрид"}, {"not": {"interests_any": "Кофе"}}]}]},
+//	 "limit": 10, "sort": "-birth", "fields": ["fname", "interests"], "cursor": "..."}
+//
+// "count": true returns the amount of matching accounts instead of them.
+//
+// A leaf is an object of filter parameters which are combined as in /accounts/filter/.
+func queryHandler(ctx *fasthttp.RequestCtx) {
+	var body map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(ctx.PostBody()))
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil {
+		ctx.Error("{}", 400)
+		return
+	}
+
+	args := &fasthttp.Args{}
+	for key, value := range body {
+		if key == "where" {
+			continue
+		}
+		if _, ok := optionParams[key]; !ok {
+			ctx.Error("{}", 400)
+			return
+		}
+		// fields could be passed as a list
+		if list, ok := value.([]interface{}); ok && key == fieldsParam {
+			fields := make([]string, 0, len(list))
+			for _, field := range list {
+				fields = append(fields, queryValue(field))
+			}
+			value = strings.Join(fields, ",")
+		}
+		args.Set(key, queryValue(value))
+	}
+
+	options, err := parseFilterOptions(args)
+	if err != nil {
+		ctx.Error("{}", 400)
+		return
+	}
+
+	explain := newExplain(ctx, "query")
+
+	responseProperties := []string{"id", "email"}
+	var predicates []*Predicate
+	if where, ok := body["where"]; ok {
+		if predicates, err = compileQuery(where, &responseProperties); err != nil {
+			ctx.Error("{}", 400)
+			return
+		}
+	}
+
+	findFiltered(ctx, predicates, responseProperties, options, explain)
+}
+
+// queryValue converts a JSON value of a leaf into the filter parameter
+func queryValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		if value {
+			return "1"
+		}
+		return "0"
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			values = append(values, queryValue(item))
+		}
+		return strings.Join(values, ",")
+	default:
+		return ""
+	}
+}
+
+// compileQuery returns predicates which all should match, response properties of leaves are collected
+func compileQuery(node interface{}, responseProperties *[]string) ([]*Predicate, error) {
+	object, ok := node.(map[string]interface{})
+	if !ok || len(object) == 0 {
+		return nil, errInvalidQuery
+	}
+
+	for _, operator := range []string{"and", "or", "not"} {
+		operand, ok := object[operator]
+		if !ok {
+			continue
+		}
+		if len(object) > 1 {
+			return nil, errInvalidQuery
+		}
+		if operator == "not" {
+			child, err := compileQuery(operand, responseProperties)
+			if err != nil {
+				return nil, err
+			}
+			return notPredicates(child), nil
+		}
+
+		operands, ok := operand.([]interface{})
+		if !ok || len(operands) == 0 {
+			return nil, errInvalidQuery
+		}
+		children := make([][]*Predicate, 0, len(operands))
+		for _, operand := range operands {
+			child, err := compileQuery(operand, responseProperties)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, child)
+		}
+		if operator == "or" {
+			return orPredicates(children), nil
+		}
+		var predicates []*Predicate
+		for _, child := range children {
+			predicates = append(predicates, child...)
+		}
+		return predicates, nil
+	}
+
+	return leafPredicates(object, responseProperties)
+}
+
+func leafPredicates(object map[string]interface{}, responseProperties *[]string) ([]*Predicate, error) {
+	args := &fasthttp.Args{}
+	for key, value := range object {
+		_, isOption := optionParams[key]
+		if _, ok := allowedParams[key]; !ok || isOption {
+			return nil, errInvalidQuery
+		}
+		args.Set(key, queryValue(value))
+	}
+
+	predicates, properties, err := filterPredicates(args)
+	if err == errNoAccounts {
+		// other branches of the expression could still match
+		return []*Predicate{newBitmapPredicate("none", NewBitmap(), costBitmapLookup, func(acc *Account) bool {
+			return false
+		})}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, property := range properties {
+		if !containsString(*responseProperties, property) {
+			*responseProperties = append(*responseProperties, property)
+		}
+	}
+
+	return predicates, nil
+}
+
+// orPredicates matches accounts which match any of conjunctions. When every conjunction has an index
+// the union of them is the source of candidates, otherwise every account is checked.
+func orPredicates(children [][]*Predicate) []*Predicate {
+	selectivity, cost := 0.0, 0.0
+	union, exact := NewBitmap(), true
+	for _, child := range children {
+		childSelectivity, childCost := conjunctionEstimate(child)
+		selectivity += childSelectivity
+		cost += childCost
+
+		bitmap, childExact := conjunctionBitmap(child)
+		if bitmap == nil {
+			union = nil
+		} else if union != nil {
+			union = union.Or(bitmap)
+		}
+		exact = exact && childExact
+	}
+
+	match := func(acc *Account) bool {
+		for _, child := range children {
+			if matchAll(child, acc) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if union == nil {
+		return []*Predicate{newScanPredicate("or", selectivity, cost, match)}
+	}
+	candidates := newBitmapPredicate("or", union, costBitmapLookup, func(acc *Account) bool {
+		return union.Contains(acc.ID)
+	})
+	if exact {
+		return []*Predicate{candidates}
+	}
+	candidates.name = "or_candidates"
+
+	return []*Predicate{candidates, newScanPredicate("or", selectivity, cost, match)}
+}
+
+// notPredicates matches accounts which don't match the conjunction, an exact index is complemented
+func notPredicates(child []*Predicate) []*Predicate {
+	if bitmap, exact := conjunctionBitmap(child); exact {
+		return []*Predicate{newBitmapPredicate("not", flagsBitmap.Get(bitmapAll).AndNot(bitmap), costBitmapLookup, func(acc *Account) bool {
+			return !bitmap.Contains(acc.ID)
+		})}
+	}
+
+	selectivity, cost := conjunctionEstimate(child)
+
+	return []*Predicate{newScanPredicate("not", 1-selectivity, cost, func(acc *Account) bool {
+		return !matchAll(child, acc)
+	})}
+}
+
+// conjunctionBitmap intersects indexes of predicates, it is exact when every predicate has index
+func conjunctionBitmap(predicates []*Predicate) (bitmap *Bitmap, exact bool) {
+	exact = true
+	for _, predicate := range predicates {
+		if predicate.index == nil {
+			exact = false
+			continue
+		}
+		if bitmap == nil {
+			bitmap = predicate.index.Bitmap()
+		} else {
+			bitmap = bitmap.And(predicate.index.Bitmap())
+		}
+	}
+	if bitmap == nil {
+		exact = false
+	}
+
+	return bitmap, exact
+}
+
+func conjunctionEstimate(predicates []*Predicate) (selectivity float64, cost float64) {
+	selectivity = 1
+	for _, predicate := range predicates {
+		selectivity *= predicate.selectivity
+		cost += predicate.cost
+	}
+
+	return selectivity, cost
+}
+
+func matchAll(predicates []*Predicate, acc *Account) bool {
+	for _, predicate := range predicates {
+		if !predicate.match(acc) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestQueryHandler(t *testing.T) {
+	firstId, cleanup := putFilterTestAccounts()
+	defer cleanup()
+
+	// every expression is limited to the test accounts by filter_a
+	cases := map[string]string{
+		`{"or": [{"fname_eq": "Другой"}, {"sex_eq": "f"}]}`:                                      "[5 4]",
+		`{"not": {"interests_contains": "filter_b"}}`:                                            "[5 3 1]",
+		`{"or": [{"fname_any": ["Другой"]}, {"interests_contains": "filter_b", "sex_eq": "m"}]}`: "[4 2 0]",
+		`{"or": [{"interests_contains": "filter_b", "fname_any": "Фильтр"}, {"sex_eq": "f"}]}`:   "[5 2 0]",
+		`{"or": [{"city_eq": "Nowhere"}, {"sex_eq": "f"}]}`:                                      "[5]",
+		`{"not": {"or": [{"fname_eq": "Другой"}, {"not": {"sex_eq": "m"}}]}}`:                    "[3 2 1 0]",
+	}
+	for where, expected := range cases {
+		body := `{"where": {"and": [{"interests_contains": "filter_a"}, ` + where + `]}, "limit": 10}`
+		if ids, status := queryIds(body, firstId); status != 200 || fmt.Sprint(ids) != expected {
+			t.Errorf("%s: expected %s, got %d %v", where, expected, status, ids)
+		}
+	}
+
+	body := `{"where": {"interests_contains": "filter_a"}, "limit": 2, "sort": "fname", "fields": ["fname"]}`
+	if ids, status := queryIds(body, firstId); status != 200 || fmt.Sprint(ids) != "[4 0]" {
+		t.Errorf("sorted query: got %d %v", status, ids)
+	}
+
+	for _, body := range []string{
+		`{"where": {"and": {}}, "limit": 1}`,
+		`{"where": {"or": [], "sex_eq": "m"}, "limit": 1}`,
+		`{"where": {"limit": 1}, "limit": 1}`,
+		`{"where": {"unknown_eq": 1}, "limit": 1}`,
+		`{"where": {"sex_eq": "m"}}`,
+		`{"limit": 1, "order": "id"}`,
+		`[]`,
+	} {
+		if _, status := queryIds(body, firstId); status != 400 {
+			t.Errorf("%s: expected 400, got %d", body, status)
+		}
+	}
+}
+
+func queryIds(body string, firstId int) ([]int, int) {
+	ctx := testRequest("POST", "/accounts/query/", body)
+	requestHandler(ctx)
+	var response struct {
+		Accounts []struct{ ID int }
+	}
+	json.Unmarshal(ctx.Response.Body(), &response)
+	ids := []int{}
+	for _, acc := range response.Accounts {
+		ids = append(ids, acc.ID-firstId)
+	}
+
+	return ids, ctx.Response.StatusCode()
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/emirpasic/gods/maps/treemap"
+)
+
+// Access paths chosen by the planner
+const (
+	planFullScan     = "full_scan"
+	planIndexScan    = "index_scan"
+	planIntersection = "intersection"
+	planEmpty        = "empty"
+)
+
+// relative costs used by the planner, one step of an iterator costs 1
+const (
+	costFieldCompare = 1
+	costMapLookup    = 2
+	costBitmapLookup = 2
+	// building a bitmap from a posting list costs per entry, bitmaps are only cloned
+	costPostingToBitmap = 2
+	costBitmapClone     = 0.1
+)
+
+// Predicate is one condition of a query. Predicates with index could be used as a source
+// of candidates, any predicate could be checked for a single account with match.
+type Predicate struct {
+	name        string
+	index       *NamedIndex
+	selectivity float64
+	cost        float64
+	match       func(acc *Account) bool
+}
+
+func newPostingPredicate(name string, index *SafeIndex, key interface{}, match func(acc *Account) bool) (*Predicate, bool) {
+	if !index.Exists(key) {
+		return nil, false
+	}
+	postings := NamedIndex{}.New([]byte(name), index.Get(key).(*treemap.Map))
+
+	return newIndexPredicate(postings, costFieldCompare, match), true
+}
+
+// newBitmapIndexPredicate uses the bitmap of the key, false is returned when there are no such accounts
+func newBitmapIndexPredicate(name string, index *BitmapIndex, key interface{}, match func(acc *Account) bool) (*Predicate, bool) {
+	bitmap := index.Get(key)
+	if bitmap.IsEmpty() {
+		return nil, false
+	}
+
+	return newBitmapPredicate(name, bitmap, costBitmapLookup, match), true
+}
+
+func newBitmapPredicate(name string, bitmap *Bitmap, cost float64, match func(acc *Account) bool) *Predicate {
+	return newIndexPredicate(newBitmapNamedIndex([]byte(name), bitmap), cost, match)
+}
+
+func newIndexPredicate(index *NamedIndex, cost float64, match func(acc *Account) bool) *Predicate {
+	return &Predicate{
+		name:        string(index.name),
+		index:       index,
+		selectivity: selectivity(index.Size()),
+		cost:        cost,
+		match:       match,
+	}
+}
+
+// newScanPredicate is a predicate without index, its selectivity is estimated by the caller
+func newScanPredicate(name string, selectivity float64, cost float64, match func(acc *Account) bool) *Predicate {
+	return &Predicate{name: name, selectivity: math.Min(selectivity, 1), cost: cost, match: match}
+}
+
+func selectivity(size int) float64 {
+	total := accountIndex.Size()
+	if total == 0 {
+		return 1
+	}
+
+	return math.Min(float64(size)/float64(total), 1)
+}
+
+// QueryPlan is an access path and residual predicates which are checked for every candidate,
+// it doesn't change while executed, so it could be executed more than once
+type QueryPlan struct {
+	strategy string
+	indexes  []*Predicate
+	residual []*Predicate
+	scanned  float64
+	cost     float64
+}
+
+// planQuery compares a full scan, a scan of every index and intersections of the smallest indexes,
+// the cost is an amount of scanned accounts multiplied by the cost of residual predicates for them
+func planQuery(predicates []*Predicate, limit int) *QueryPlan {
+	var indexed []*Predicate
+	for _, predicate := range predicates {
+		if predicate.index != nil {
+			indexed = append(indexed, predicate)
+		}
+	}
+	sort.SliceStable(indexed, func(i, j int) bool {
+		return indexed[i].index.Size() < indexed[j].index.Size()
+	})
+
+	best := newQueryPlan(planFullScan, nil, predicates, accountIndex.Size(), 0, limit)
+	for _, predicate := range indexed {
+		plan := newQueryPlan(planIndexScan, []*Predicate{predicate}, predicates, predicate.index.Size(), 0, limit)
+		if plan.cost < best.cost {
+			best = plan
+		}
+	}
+
+	// predicates are independent, so the size of intersection is estimated by their selectivities
+	for k := 2; k <= len(indexed); k++ {
+		buildCost, size := 0.0, float64(accountIndex.Size())
+		for _, predicate := range indexed[:k] {
+			buildCost += intersectionCost(predicate)
+			size *= predicate.selectivity
+		}
+		plan := newQueryPlan(planIntersection, indexed[:k], predicates, int(math.Ceil(size)), buildCost, limit)
+		if plan.cost < best.cost {
+			best = plan
+		}
+	}
+
+	return best
+}
+
+func intersectionCost(predicate *Predicate) float64 {
+	if predicate.index.bitmap != nil {
+		return float64(predicate.index.Size()) * costBitmapClone
+	}
+
+	return float64(predicate.index.Size()) * costPostingToBitmap
+}
+
+func newQueryPlan(strategy string, indexes []*Predicate, predicates []*Predicate, size int, buildCost float64, limit int) *QueryPlan {
+	plan := &QueryPlan{strategy: strategy, indexes: indexes, residual: residualPredicates(predicates, indexes)}
+	plan.estimate(size, buildCost, limit)
+
+	return plan
+}
+
+// residualPredicates are not covered by indexes, the cheapest and the most selective are checked first
+func residualPredicates(predicates []*Predicate, indexes []*Predicate) []*Predicate {
+	covered := make(map[*Predicate]struct{}, len(indexes))
+	for _, predicate := range indexes {
+		covered[predicate] = struct{}{}
+	}
+
+	residual := make([]*Predicate, 0, len(predicates))
+	for _, predicate := range predicates {
+		if _, ok := covered[predicate]; !ok {
+			residual = append(residual, predicate)
+		}
+	}
+	sort.SliceStable(residual, func(i, j int) bool {
+		return residual[i].rank() < residual[j].rank()
+	})
+
+	return residual
+}
+
+func (p *Predicate) rank() float64 {
+	if p.selectivity >= 1 {
+		return math.Inf(1)
+	}
+
+	return p.cost / (1 - p.selectivity)
+}
+
+// estimate calculates the amount of accounts scanned until limit of them passes residual predicates
+func (plan *QueryPlan) estimate(size int, buildCost float64, limit int) {
+	passed := 1.0
+	for _, predicate := range plan.residual {
+		passed *= predicate.selectivity
+	}
+
+	plan.scanned = float64(size)
+	if passed > 0 && float64(limit)/passed < plan.scanned {
+		plan.scanned = float64(limit) / passed
+	}
+
+	// predicates are checked until the first failed one
+	checkCost, reached := 0.0, 1.0
+	for _, predicate := range plan.residual {
+		checkCost += reached * predicate.cost
+		reached *= predicate.selectivity
+	}
+
+	plan.cost = buildCost + plan.scanned*(1+checkCost)
+}
+
+// source returns candidates in descending id order
+func (plan *QueryPlan) source() *NamedIndex {
+	switch plan.strategy {
+	case planIndexScan:
+		return plan.indexes[0].index
+	case planIntersection:
+		candidates := plan.indexes[0].index.Bitmap()
+		for _, predicate := range plan.indexes[1:] {
+			if candidates.IsEmpty() {
+				break
+			}
+			candidates = candidates.And(predicate.index.Bitmap())
+		}
+		return newBitmapNamedIndex([]byte(planIntersection), candidates)
+	default:
+		return NamedIndex{}.New([]byte("default"), accountIndex)
+	}
+}
+
+// Execute calls f for every account which passes all predicates until f returns false
+func (plan *QueryPlan) Execute(f func(acc *Account) bool) {
+	plan.execute(nil, f)
+}
+
+// execute traces scanned accounts and residual predicates when explain is set
+func (plan *QueryPlan) execute(explain *Explain, f func(acc *Account) bool) {
+	plan.executeBefore(0, explain, f)
+}
+
+// executeBefore resumes the scan from the first id lower than before, 0 means the scan from the start
+func (plan *QueryPlan) executeBefore(before int, explain *Explain, f func(acc *Account) bool) {
+	source := plan.source()
+	if explain != nil {
+		explain.Source(source)
+	}
+
+	it := source.Iterator()
+	if before > 0 {
+		it = source.IteratorBefore(before)
+	}
+	for it.Next() {
+		account := it.Value().(*Account)
+		if explain != nil {
+			explain.Scanned++
+			if !plan.traceResidual(account, explain) {
+				continue
+			}
+			explain.Returned++
+		} else if !plan.matchResidual(account) {
+			continue
+		}
+		if !f(account) {
+			return
+		}
+	}
+}
+
+func (plan *QueryPlan) matchResidual(account *Account) bool {
+	for _, predicate := range plan.residual {
+		if !predicate.match(account) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (plan *QueryPlan) traceResidual(account *Account, explain *Explain) bool {
+	for i, predicate := range plan.residual {
+		if !explain.residual[i].Evaluate(predicate.match(account)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Find returns up to limit accounts which pass all predicates
+func (plan *QueryPlan) Find(limit int) []*Account {
+	return plan.find(limit, 0, nil)
+}
+
+// FindBefore returns the next page of accounts after the last returned id
+func (plan *QueryPlan) FindBefore(limit int, before int) []*Account {
+	return plan.find(limit, before, nil)
+}
+
+func (plan *QueryPlan) find(limit int, before int, explain *Explain) []*Account {
+	var found []*Account
+	plan.executeBefore(before, explain, func(acc *Account) bool {
+		found = append(found, acc)
+		return len(found) < limit
+	})
+
+	return found
+}
+
+// Count returns the amount of accounts which pass all predicates,
+// when indexes cover all of them the size of the source is the answer
+func (plan *QueryPlan) Count() int {
+	return plan.count(nil)
+}
+
+func (plan *QueryPlan) count(explain *Explain) int {
+	if len(plan.residual) == 0 {
+		source := plan.source()
+		if explain != nil {
+			explain.Source(source)
+			explain.Returned = source.Size()
+		}
+		return source.Size()
+	}
+
+	count := 0
+	plan.execute(explain, func(acc *Account) bool {
+		count++
+		return true
+	})
+
+	return count
+}
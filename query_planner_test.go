@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPlanQuery(t *testing.T) {
+	const firstId, count = 990100000, 1000
+	writeLocked(func() {
+		for id := firstId; id < firstId+count; id++ {
+			accountIndex.Put(id, &Account{ID: id})
+		}
+	})
+	defer writeLocked(func() {
+		for id := firstId; id < firstId+count; id++ {
+			accountIndex.Remove(id)
+		}
+	})
+
+	bitmapOf := func(from, to int) *Bitmap {
+		b := NewBitmap()
+		for id := firstId + from; id < firstId+to; id++ {
+			b.Add(id)
+		}
+		return b
+	}
+	predicateOf := func(name string, b *Bitmap) *Predicate {
+		return newBitmapPredicate(name, b, costBitmapLookup, func(acc *Account) bool {
+			return b.Contains(acc.ID)
+		})
+	}
+
+	tiny := predicateOf("tiny", bitmapOf(0, 10))
+	wide := predicateOf("wide", bitmapOf(0, 900))
+	left := predicateOf("left", bitmapOf(0, 300))
+	right := predicateOf("right", bitmapOf(270, 570))
+	scan := newScanPredicate("scan", 0.5, costFieldCompare, func(acc *Account) bool {
+		return acc.ID%2 == 0
+	})
+
+	cases := []struct {
+		predicates []*Predicate
+		limit      int
+		strategy   string
+		found      int
+	}{
+		{[]*Predicate{wide, tiny}, 100, planIndexScan, 10},
+		{[]*Predicate{left, right}, 1000, planIntersection, 30},
+		{[]*Predicate{scan}, 10, planFullScan, 10},
+		{[]*Predicate{scan, tiny}, 100, planIndexScan, 5},
+	}
+
+	for _, c := range cases {
+		var plan *QueryPlan
+		var found []*Account
+		readLocked(func() {
+			plan = planQuery(c.predicates, c.limit)
+			found = plan.Find(c.limit)
+		})
+		if plan.strategy != c.strategy {
+			t.Errorf("expected %s, got %s with cost %f", c.strategy, plan.strategy, plan.cost)
+		}
+		if len(found) != c.found {
+			t.Errorf("%s: expected %d accounts, got %d", plan.strategy, c.found, len(found))
+		}
+		for i := 1; i < len(found); i++ {
+			if found[i-1].ID <= found[i].ID {
+				t.Fatalf("%s: accounts are not in descending id order", plan.strategy)
+			}
+		}
+	}
+
+	// the plan could be executed again
+	plan := planQuery([]*Predicate{left, right}, 5)
+	if len(plan.Find(5)) != 5 || len(plan.Find(5)) != 5 {
+		t.Error("plan is not reusable")
+	}
+}
+
+func TestResidualPredicatesOrder(t *testing.T) {
+	expensive := newScanPredicate("expensive", 0.1, 10, nil)
+	cheap := newScanPredicate("cheap", 0.9, 1, nil)
+	selective := newScanPredicate("selective", 0.01, 2, nil)
+
+	residual := residualPredicates([]*Predicate{expensive, cheap, selective}, nil)
+	if residual[0] != selective || residual[1] != cheap || residual[2] != expensive {
+		t.Errorf("unexpected order %s, %s, %s", residual[0].name, residual[1].name, residual[2].name)
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"math"
 	"sort"
 	"strconv"
@@ -27,7 +26,7 @@ func recommendHandler(ctx *fasthttp.RequestCtx, accountId int) {
 
 	validQueryArgs := true
 	ctx.QueryArgs().VisitAll(func(key, value []byte) {
-		if _, ok := allowedParams[string(key)]; !ok {
+		if _, ok := allowedParams[string(key)]; !ok && !isExplainParam(key) {
 			validQueryArgs = false
 			return
 		}
@@ -44,22 +43,6 @@ func recommendHandler(ctx *fasthttp.RequestCtx, accountId int) {
 		return
 	}
 
-	var index *treemap.Map
-
-	vnidxpool := namedIndexPool.Get()
-	namedIndex := vnidxpool.(*NamedIndex)
-
-	vmap := treemapPool.Get()
-	suitableIndexes := vmap.(*treemap.Map)
-	switch requestedAccount.Sex {
-	case "m":
-		femaleIndex := sexIndex.Get("f").(*treemap.Map)
-		suitableIndexes.Put(femaleIndex.Size(), namedIndex.Update([]byte("sex_f"), femaleIndex))
-	case "f":
-		maleIndex := sexIndex.Get("m").(*treemap.Map)
-		suitableIndexes.Put(maleIndex.Size(), namedIndex.Update([]byte("sex_m"), maleIndex))
-	}
-
 	var countryEqF []byte
 	var cityEqF []byte
 	if ctx.QueryArgs().Has("country") {
@@ -77,100 +60,75 @@ func recommendHandler(ctx *fasthttp.RequestCtx, accountId int) {
 		}
 	}
 
-	var foundAccounts []*CompatibilityResult
-
-	filters := make(map[string]interface{})
+	explain := newExplain(ctx, "recommend")
 
-	filters["compatibility"] = 1
-
-	var countryEqFilter string
-	if len(countryEqF) > 0 {
-		countryEqFilter = string(countryEqF)
-		filters["country"] = 1
-		if countryIndex.Exists(countryEqFilter) {
-			currIndex := countryIndex.Get(countryEqFilter).(*treemap.Map)
-			suitableIndexes.Put(
-				currIndex.Size(),
-				namedIndex.Update([]byte("country"), currIndex),
-			)
-		}
+	countryEqFilter, cityEqFilter := string(countryEqF), string(cityEqF)
+	oppositeSex := map[string]string{"m": "f", "f": "m"}[requestedAccount.Sex]
+	// only accounts of the opposite sex are compatible, the bitmap is empty when there are no such accounts
+	sexPredicate, ok := newBitmapIndexPredicate("sex", sexBitmap, oppositeSex, func(acc *Account) bool {
+		return acc.Sex == oppositeSex
+	})
+	if oppositeSex == "" || !ok {
+		emptyExplainedResponse(ctx, explain, emptyResponse)
+		return
 	}
-	var cityEqFilter string
-	if len(cityEqF) > 0 {
-		cityEqFilter = string(cityEqF)
-		filters["city"] = 1
-		if cityIndex.Exists(cityEqFilter) {
-			currIndex := cityIndex.Get(cityEqFilter).(*treemap.Map)
-			suitableIndexes.Put(
-				currIndex.Size(),
-				namedIndex.Update([]byte("city"), currIndex),
-			)
+	predicates := []*Predicate{sexPredicate}
+
+	postingFilters := []struct {
+		name  string
+		index *SafeIndex
+		value string
+		match func(acc *Account) bool
+	}{
+		{"country", countryIndex, countryEqFilter, func(acc *Account) bool {
+			return acc.Country == countryEqFilter
+		}},
+		{"city", cityIndex, cityEqFilter, func(acc *Account) bool {
+			return acc.City == cityEqFilter
+		}},
+	}
+	for _, filter := range postingFilters {
+		if filter.value == "" {
+			continue
 		}
-	}
-
-	var selectedIndexName []byte
-	if suitableIndexes.Size() > 0 {
-		if _, shortestIndex := suitableIndexes.Min(); &shortestIndex != nil {
-			res := shortestIndex.(*NamedIndex)
-			index = res.index
-			selectedIndexName = res.name
+		predicate, ok := newPostingPredicate(filter.name, filter.index, filter.value, filter.match)
+		if !ok {
+			emptyExplainedResponse(ctx, explain, emptyResponse)
+			return
 		}
+		predicates = append(predicates, predicate)
 	}
 
-	namedIndexPool.Put(vnidxpool)
-	treemapPool.Put(vmap)
+	// common interests are counted for every passed account, the predicate is estimated by the most popular interest
+	predicates = append(predicates, newScanPredicate("compatibility", interestsSelectivity(requestedAccount), costMapLookup*float64(len(requestedAccount.interestsMap)), func(acc *Account) bool {
+		return intersectionsCount(requestedAccount.interestsMap, acc.interestsMap) > 0
+	}))
 
-	if index == nil || index.Size() == 0 {
-		emptyResponse(ctx)
-		return
+	// all compatible accounts are sorted, so there is no limit for the plan
+	plan := planQuery(predicates, accountIndex.Size())
+	if explain != nil {
+		explain.Plan(plan)
 	}
 
-	filtersCount := len(filters)
-
-	it := index.Iterator()
-	for it.Next() {
-		passedFilters := 0
-		account := it.Value().(*Account)
-
-		if requestedAccount.Sex == account.Sex {
-			continue
-		}
-
-		if countryEqFilter != "" {
-			if bytes.Equal(selectedIndexName, []byte("country")) || account.Country == countryEqFilter {
-				passedFilters += 1
-			} else {
-				continue
-			}
-		}
-
-		if cityEqFilter != "" {
-			if bytes.Equal(selectedIndexName, []byte("city ")) || account.City == cityEqFilter {
-				passedFilters += 1
-			} else {
-				continue
-			}
-		}
-
-		interestsIntersections := intersectionsCount(requestedAccount.interestsMap, account.interestsMap)
-		if interestsIntersections > 0 {
-			passedFilters += 1
-		} else {
-			continue
-		}
+	var foundAccounts []*CompatibilityResult
 
+	plan.execute(explain, func(account *Account) bool {
 		// WHERE commonInterests>0 ORDER BY premium_now, status, commonInterests, ageDiffSeconds
+		foundAccounts = append(foundAccounts, &CompatibilityResult{
+			id:              account.ID,
+			hasPremiumNow:   account.hasActivePremium(now),
+			status:          account.Status,
+			commonInterests: intersectionsCount(requestedAccount.interestsMap, account.interestsMap),
+			ageDiff:         int(math.Abs(float64(requestedAccount.Birth - account.Birth))),
+			account:         account,
+		})
 
-		if passedFilters == filtersCount {
-			foundAccounts = append(foundAccounts, &CompatibilityResult{
-				id:              account.ID,
-				hasPremiumNow:   account.hasActivePremium(now),
-				status:          account.Status,
-				commonInterests: interestsIntersections,
-				ageDiff:         int(math.Abs(float64(requestedAccount.Birth - account.Birth))),
-				account:         account,
-			})
-		}
+		return true
+	})
+
+	if explain != nil {
+		explainResponse(ctx, explain)
+		return
 	}
 
 	if len(foundAccounts) > 0 {
@@ -273,3 +231,17 @@ func (s compatibilitySort) Less(i, j int) bool {
 
 	return acc1.id < acc2.id
 }
+
+// interestsSelectivity is a share of accounts which have the most popular interest of the account
+func interestsSelectivity(account *Account) float64 {
+	size := 0
+	for interest := range account.interestsMap {
+		if interestsIndex.Exists(interest) {
+			if postings := interestsIndex.Get(interest).(*treemap.Map); postings.Size() > size {
+				size = postings.Size()
+			}
+		}
+	}
+
+	return selectivity(size)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestRecommendHandler(t *testing.T) {
+	firstId, cleanup := putFilterTestAccounts()
+	defer cleanup()
+
+	recommend := func(offset int, query string) string {
+		ctx := testRequest("GET", fmt.Sprintf("/accounts/%d/recommend/?limit=10%s", firstId+offset, query), "")
+		requestHandler(ctx)
+		if ctx.Response.StatusCode() != 200 {
+			return fmt.Sprint(ctx.Response.StatusCode())
+		}
+		var response struct {
+			Accounts []struct{ ID int }
+		}
+		json.Unmarshal(ctx.Response.Body(), &response)
+		ids := []int{}
+		for _, acc := range response.Accounts {
+			ids = append(ids, acc.ID-firstId)
+		}
+		return fmt.Sprint(ids)
+	}
+
+	cases := []struct {
+		offset   int
+		query    string
+		expected string
+	}{
+		{5, "", "[4 3 2 1 0]"},
+		{5, "&city=Москва", "[1]"},
+		{5, "&city=Неизвестный", "[]"},
+		{5, "&country=Неизвестная", "[]"},
+		{5, "&city=", "400"},
+		{0, "", "[5]"},
+	}
+	for _, c := range cases {
+		if ids := recommend(c.offset, c.query); ids != c.expected {
+			t.Errorf("%d%s: expected %s, got %s", c.offset, c.query, c.expected, ids)
+		}
+	}
+
+	// an account without sex has no opposite sex to recommend
+	writeLocked(func() {
+		value, _ := accountIndex.Get(firstId + 5)
+		value.(*Account).Update(map[string]interface{}{"sex": ""})
+	})
+	for _, query := range []string{"", "&city=Москва"} {
+		if ids := recommend(5, query); ids != "[]" {
+			t.Errorf("without sex%s: expected [], got %s", query, ids)
+		}
+	}
+}
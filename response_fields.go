@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+const fieldsParam = "fields"
+
+// inferredFields in fields= stands for the fields which are added by passed filters
+const inferredFields = "*"
+
+var errInvalidFields = errors.New("invalid fields")
+
+var responseFields = map[string]struct{}{
+	"id": {}, "email": {}, "sex": {}, "status": {}, "fname": {}, "sname": {}, "phone": {},
+	"country": {}, "city": {}, "birth": {}, "premium": {}, "joined": {}, "interests": {}, "likes_count": {},
+}
+
+// parseResponseFields validates the list of requested fields, "*" is kept for projectFields
+func parseResponseFields(value []byte) ([]string, error) {
+	fields := strings.Split(string(value), ",")
+	for _, field := range fields {
+		if _, ok := responseFields[field]; !ok && field != inferredFields {
+			return nil, errInvalidFields
+		}
+	}
+
+	return fields, nil
+}
+
+// projectFields returns requested fields in the order of the list, id is always the first one.
+// The list overrides the inferred fields unless it contains "*".
+func projectFields(requested []string, inferred []string) []string {
+	fields := []string{"id"}
+	added := map[string]struct{}{"id": {}}
+	add := func(field string) {
+		if _, ok := added[field]; !ok {
+			added[field] = struct{}{}
+			fields = append(fields, field)
+		}
+	}
+
+	for _, field := range requested {
+		if field != inferredFields {
+			add(field)
+			continue
+		}
+		for _, field := range inferred {
+			add(field)
+		}
+	}
+
+	return fields
+}
@@ -43,3 +43,20 @@ func (idx *SafeIndex) Get(key interface{}) interface{} {
 
 	return idx.v[key]
 }
+
+func (idx *SafeIndex) Size() int {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	return len(idx.v)
+}
+
+// Each calls f for every key under the lock, f must not modify the index
+func (idx *SafeIndex) Each(f func(key interface{}, value interface{})) {
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+
+	for key, value := range idx.v {
+		f(key, value)
+	}
+}
@@ -109,9 +109,10 @@ func suggestHandler(ctx *fasthttp.RequestCtx, accountId int) {
 
 		if passedFilters == filtersCount {
 			suggestsByOneUser := treemap.NewWith(inverseIntComparator)
-			for likeId := range account.likes {
+			for _, like := range account.likes {
+				likeId := int(like.ID)
 				// ignore exists like
-				if _, exists := requestedAccount.likes[likeId]; exists {
+				if requestedAccount.likes.Contains(likeId) {
 					continue
 				}
 				if suggestedLike, ok := accountIndex.Get(likeId); ok {
@@ -0,0 +1,158 @@
+package main
+
+import "sync"
+
+// PrefixTrie maps names to account ids and answers "starts with" lookups, keys are runes
+type PrefixTrie struct {
+	root *trieNode
+	mux  sync.RWMutex
+}
+
+type trieNode struct {
+	children map[rune]*trieNode
+	ids      *Bitmap // accounts with exactly this name
+}
+
+func NewPrefixTrie() *PrefixTrie {
+	return &PrefixTrie{root: &trieNode{}}
+}
+
+func (t *PrefixTrie) Add(name string, id int) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	node := t.root
+	for _, r := range name {
+		if node.children == nil {
+			node.children = make(map[rune]*trieNode)
+		}
+		child, ok := node.children[r]
+		if !ok {
+			child = &trieNode{}
+			node.children[r] = child
+		}
+		node = child
+	}
+	if node.ids == nil {
+		node.ids = NewBitmap()
+	}
+	node.ids.Add(id)
+}
+
+func (t *PrefixTrie) Remove(name string, id int) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	// path is kept to drop branches which became empty
+	path := make([]*trieNode, 0, len(name)+1)
+	runes := make([]rune, 0, len(name))
+	node := t.root
+	path = append(path, node)
+	for _, r := range name {
+		child, ok := node.children[r]
+		if !ok {
+			return
+		}
+		node = child
+		path = append(path, node)
+		runes = append(runes, r)
+	}
+	if node.ids == nil {
+		return
+	}
+	node.ids.Remove(id)
+
+	for i := len(path) - 1; i > 0; i-- {
+		current := path[i]
+		if !current.ids.IsEmpty() || len(current.children) > 0 {
+			break
+		}
+		delete(path[i-1].children, runes[i-1])
+	}
+}
+
+func (t *PrefixTrie) Contains(name string, id int) bool {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	node := t.root
+	for _, r := range name {
+		child, ok := node.children[r]
+		if !ok {
+			return false
+		}
+		node = child
+	}
+
+	return node.ids.Contains(id)
+}
+
+// Each calls f for every name and id under the lock, f must not modify the trie
+func (t *PrefixTrie) Each(f func(name string, id int)) {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	var walk func(node *trieNode, name []rune)
+	walk = func(node *trieNode, name []rune) {
+		if !node.ids.IsEmpty() {
+			nameStr := string(name)
+			node.ids.Each(func(id int) bool {
+				f(nameStr, id)
+				return true
+			})
+		}
+		for r, child := range node.children {
+			walk(child, append(name, r))
+		}
+	}
+	walk(t.root, nil)
+}
+
+// Prefix returns ids of accounts which names start with prefix
+func (t *PrefixTrie) Prefix(prefix string) *Bitmap {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	node := t.root
+	for _, r := range prefix {
+		child, ok := node.children[r]
+		if !ok {
+			return NewBitmap()
+		}
+		node = child
+	}
+
+	result := NewBitmap()
+	var collect func(node *trieNode)
+	collect = func(node *trieNode) {
+		if !node.ids.IsEmpty() {
+			result.OrWith(node.ids)
+		}
+		for _, child := range node.children {
+			collect(child)
+		}
+	}
+	collect(node)
+
+	return result
+}
+
+// Size returns the number of distinct names
+func (t *PrefixTrie) Size() int {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	var count func(node *trieNode) int
+	count = func(node *trieNode) int {
+		total := 0
+		if !node.ids.IsEmpty() {
+			total++
+		}
+		for _, child := range node.children {
+			total += count(child)
+		}
+		return total
+	}
+
+	return count(t.root)
+}
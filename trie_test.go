@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestPrefixTrie(t *testing.T) {
+	trie := NewPrefixTrie()
+	trie.Add("Иванов", 1)
+	trie.Add("Иванова", 2)
+	trie.Add("Ивашин", 3)
+	trie.Add("Петров", 4)
+	trie.Add("Иванов", 5)
+
+	if ids := trie.Prefix("Ива").ToSlice(); !reflect.DeepEqual(ids, []int{5, 3, 2, 1}) {
+		t.Error("Ива:", ids)
+	}
+	if ids := trie.Prefix("Иванов").ToSlice(); !reflect.DeepEqual(ids, []int{5, 2, 1}) {
+		t.Error("Иванов:", ids)
+	}
+	if !trie.Prefix("Ивв").IsEmpty() {
+		t.Error("expected no matches for Ивв")
+	}
+
+	trie.Remove("Иванова", 2)
+	trie.Remove("Ивашин", 3)
+	if ids := trie.Prefix("Ива").ToSlice(); !reflect.DeepEqual(ids, []int{5, 1}) {
+		t.Error("after remove:", ids)
+	}
+	if _, ok := trie.root.children['И'].children['в'].children['а'].children['ш']; ok {
+		t.Error("empty branch was not removed")
+	}
+}
+
+func TestPrefixTrieShortPrefix(t *testing.T) {
+	trie := NewPrefixTrie()
+	for id := 1; id <= 10000; id++ {
+		trie.Add("А"+strconv.Itoa(id), id)
+	}
+
+	if ids := trie.Prefix("А"); ids.Cardinality() != 10000 || !ids.Contains(1) || !ids.Contains(10000) {
+		t.Error("А:", ids.Cardinality())
+	}
+	if ids := trie.Prefix("А1"); ids.Cardinality() != 1+10+100+1000+1 {
+		t.Error("А1:", ids.Cardinality())
+	}
+}
+
+func BenchmarkPrefixTrieShortPrefix(b *testing.B) {
+	trie := NewPrefixTrie()
+	for id := 1; id <= 100000; id++ {
+		trie.Add("А"+strconv.Itoa(id), id)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.Prefix("А")
+	}
+}
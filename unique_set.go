@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+)
+
+var (
+	uniqueIds = NewUniqueSet(func(value interface{}) bool {
+		_, found := accountIndex.Get(value)
+		return found
+	})
+	uniqueEmails = NewUniqueSet(emailIndex.Exists)
+	uniquePhones = NewUniqueSet(phoneIndex.Exists)
+)
+
+// UniqueSet holds values which are validated by requests but are not written to the index yet,
+// a value could be reserved once and only when the index doesn't contain it
+type UniqueSet struct {
+	exists   func(value interface{}) bool
+	reserved map[interface{}]struct{}
+	mux      sync.Mutex
+}
+
+func NewUniqueSet(exists func(value interface{}) bool) *UniqueSet {
+	return &UniqueSet{exists: exists, reserved: make(map[interface{}]struct{})}
+}
+
+func (s *UniqueSet) Reserve(value interface{}) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.reserved[value]; ok || s.exists(value) {
+		return false
+	}
+	s.reserved[value] = struct{}{}
+
+	return true
+}
+
+func (s *UniqueSet) Release(value interface{}) {
+	s.mux.Lock()
+	delete(s.reserved, value)
+	s.mux.Unlock()
+}
+
+type reservation struct {
+	set   *UniqueSet
+	value interface{}
+}
+
+// Reservations of one request, they are released when the request fails
+// or committed together with the write which stores the values
+type Reservations []reservation
+
+func (r *Reservations) Reserve(set *UniqueSet, value interface{}) bool {
+	if !set.Reserve(value) {
+		return false
+	}
+	*r = append(*r, reservation{set, value})
+
+	return true
+}
+
+func (r Reservations) Release() {
+	for _, reserved := range r {
+		reserved.set.Release(reserved.value)
+	}
+}
+
+// Commit applies the write, which is called under the store write lock,
+// values are in indexes after it so reservations aren't needed anymore
+func (r Reservations) Commit(apply func()) {
+	apply()
+	r.Release()
+}
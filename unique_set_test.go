@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestUniqueReservations(t *testing.T) {
+	defer writeLocked(func() {
+		for _, id := range []int{990003001, 990003002} {
+			if value, found := accountIndex.Get(id); found {
+				unindexAccount(value.(*Account))
+				accountIndex.Remove(id)
+			}
+		}
+	})
+
+	// the first write is validated but not applied yet
+	first := testRequest("POST", "/accounts/new/", `{"id":990003001,"email":"unique@test.local","phone":"8(990)3001"}`)
+	first.Request.Header.Set(syncWriteHeader, "1")
+	readLocked(func() {
+		routePostRequest(first)
+	})
+
+	for _, body := range []string{
+		`{"id":990003001,"email":"other@test.local"}`,
+		`{"id":990003002,"email":"unique@test.local"}`,
+		`{"id":990003002,"email":"other@test.local","phone":"8(990)3001"}`,
+	} {
+		ctx := testRequest("POST", "/accounts/new/", body)
+		requestHandler(ctx)
+		if ctx.Response.StatusCode() != 400 {
+			t.Errorf("reserved value was accepted: %s", body)
+		}
+	}
+
+	// failed requests release their reservations
+	if !uniqueEmails.Reserve("other@test.local") {
+		t.Error("reservation of failed request was not released")
+	}
+	uniqueEmails.Release("other@test.local")
+
+	commitWrite(first)
+	if first.Response.StatusCode() != 201 {
+		t.Fatalf("account was not created: %s", first.Response.Body())
+	}
+	if len(uniqueEmails.reserved) != 0 || len(uniqueIds.reserved) != 0 || len(uniquePhones.reserved) != 0 {
+		t.Error("reservations were not released by the commit")
+	}
+
+	ctx := testRequest("POST", "/accounts/990003001/", `{"email":"unique@test.local"}`)
+	requestHandler(ctx)
+	if ctx.Response.StatusCode() != 400 {
+		t.Error("existing email was accepted by update")
+	}
+}
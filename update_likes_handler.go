@@ -17,7 +17,8 @@ type LikesPayload struct {
 
 func updateLikesHandler(ctx *fasthttp.RequestCtx) {
 	var likes LikesPayload
-	jsonData := ctx.PostBody()
+	// body is reused by fasthttp after the response, while the write could be applied later
+	jsonData := append([]byte(nil), ctx.PostBody()...)
 	if err := json.Unmarshal(jsonData, &likes); err != nil {
 		ctx.Error(`{"err":"invalid_payload"}`, 400)
 		return
@@ -34,8 +35,9 @@ func updateLikesHandler(ctx *fasthttp.RequestCtx) {
 		}
 	}
 
-	// updating in goroutine
-	go updateLikes(jsonData)
+	applyWrite(ctx, func() {
+		updateLikes(jsonData)
+	})
 
 	updatedSuccessResponse(ctx)
 	return
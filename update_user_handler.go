@@ -39,11 +39,6 @@ func updateUserHandler(ctx *fasthttp.RequestCtx, accountId int) {
 				ctx.Error(`{"err":"incorrect_email_long"}`, 400)
 				return
 			}
-			// unique email
-			if emailIndex.Exists(email) {
-				ctx.Error(`{"err":"email_already_exists"}`, 400)
-				return
-			}
 		} else {
 			ctx.Error(`{"err":"empty_email_field"}`, 400)
 			return
@@ -58,11 +53,6 @@ func updateUserHandler(ctx *fasthttp.RequestCtx, accountId int) {
 				ctx.Error(`{"err":"phone_too_long"}`, 400)
 				return
 			}
-			// unique phone
-			if phoneIndex.Exists(phone) {
-				ctx.Error(`{"err":"phone_already_exists"}`, 400)
-				return
-			}
 		} else {
 			ctx.Error(`{"err":"empty_phone_field"}`, 400)
 			return
@@ -205,19 +195,57 @@ func updateUserHandler(ctx *fasthttp.RequestCtx, accountId int) {
 
 	if value, ok := data["likes"]; ok {
 		if value != nil {
-			_, ok := value.([]map[string]interface{})
+			list, ok := value.([]interface{})
 			if !ok {
 				ctx.Error(`{"err":"incorrect_likes_format"}`, 400)
 				return
 			}
+			// the list replaces all likes of the account, so it is parsed here once
+			var likes Likes
+			for _, v := range list {
+				like, ok := v.(map[string]interface{})
+				if !ok {
+					ctx.Error(`{"err":"incorrect_likes_format"}`, 400)
+					return
+				}
+				likeeId, idOk := like["id"].(float64)
+				ts, tsOk := like["ts"].(float64)
+				if !idOk || !tsOk {
+					ctx.Error(`{"err":"incorrect_likes_format"}`, 400)
+					return
+				}
+				if _, found := accountIndex.Get(int(likeeId)); !found {
+					ctx.Error(`{"err":"likee_not_found"}`, 400)
+					return
+				}
+				likes.Append(int(likeeId), int(ts))
+			}
+			data["likes"] = likes
 		} else {
-			ctx.Error(`{"err":"empty_premium_field"}`, 400)
+			ctx.Error(`{"err":"empty_likes_field"}`, 400)
 			return
 		}
 	}
 
-	// updating in goroutine
-	go account.Update(data)
+	// unique values are reserved after the validation, so nothing has to be released on errors above
+	var reservations Reservations
+
+	if email, ok := data["email"]; ok && !reservations.Reserve(uniqueEmails, email) {
+		ctx.Error(`{"err":"email_already_exists"}`, 400)
+		return
+	}
+
+	if phone, ok := data["phone"]; ok && !reservations.Reserve(uniquePhones, phone) {
+		reservations.Release()
+		ctx.Error(`{"err":"phone_already_exists"}`, 400)
+		return
+	}
+
+	applyWrite(ctx, func() {
+		reservations.Commit(func() {
+			account.Update(data)
+		})
+	})
 
 	updatedSuccessResponse(ctx)
 	return
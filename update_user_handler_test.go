@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestUpdateReplacesLikes(t *testing.T) {
+	liker := &Account{ID: 990002001}
+	likees := []*Account{{ID: 990002002}, {ID: 990002003}}
+	writeLocked(func() {
+		for _, acc := range append(likees, liker) {
+			indexAccount(acc)
+			accountIndex.Put(acc.ID, acc)
+		}
+		reindexAccount(liker, []string{"likes"}, func() {
+			liker.AppendLike(990002002, 100)
+		})
+	})
+	defer writeLocked(func() {
+		for _, acc := range append(likees, liker) {
+			unindexAccount(acc)
+			accountIndex.Remove(acc.ID)
+		}
+	})
+
+	ctx := testRequest("POST", "/accounts/990002001/", `{"likes":[{"id":990002003,"ts":200},{"id":990002003,"ts":300}]}`)
+	ctx.Request.Header.Set(syncWriteHeader, "1")
+	requestHandler(ctx)
+	if ctx.Response.StatusCode() != 202 {
+		t.Fatalf("likes were rejected: %s", ctx.Response.Body())
+	}
+
+	readLocked(func() {
+		if liker.likes.Contains(990002002) || likeeIndex.Count(990002002) != 0 {
+			t.Error("old like was not removed")
+		}
+		if ts, found := likeeIndex.Find(990002003, liker.ID); !found || ts != 250 {
+			t.Errorf("new like was not indexed, ts %d", ts)
+		}
+	})
+
+	ctx = testRequest("POST", "/accounts/990002001/", `{"likes":[{"id":990002999,"ts":200}]}`)
+	requestHandler(ctx)
+	if ctx.Response.StatusCode() != 400 {
+		t.Error("like of unknown account was accepted")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Write modes of POST handlers:
+// async - every write is applied in its own goroutine after the response (default),
+// sync - writes are applied before the response,
+// queue - writes are applied in order by a single writer after the response.
+const (
+	writeModeAsync = "async"
+	writeModeSync  = "sync"
+	writeModeQueue = "queue"
+
+	// request header which demands the write to be applied before the response in any mode
+	syncWriteHeader = "X-Sync-Write"
+
+	writeQueueSize = 4096
+
+	// queued writes which are applied under one acquisition of the write lock
+	writeBatchSize = 256
+
+	pendingWriteKey = "pendingWrite"
+)
+
+var (
+	writeMode  = os.Getenv("WRITE_MODE")
+	writeQueue chan writeJob
+
+	// storeLock guards accounts and all indexes: requests read them under the read lock,
+	// writes are applied under the write lock, so readers never see a half-applied write
+	storeLock sync.RWMutex
+)
+
+type writeJob struct {
+	apply func()
+	done  chan struct{}
+}
+
+func readLocked(f func()) {
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+
+	f()
+}
+
+func writeLocked(f func()) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	f()
+}
+
+func startWriter() {
+	if writeMode != writeModeQueue {
+		return
+	}
+
+	writeQueue = make(chan writeJob, writeQueueSize)
+	go func() {
+		batch := make([]writeJob, 0, writeBatchSize)
+		for job := range writeQueue {
+			batch = append(batch[:0], job)
+		drain:
+			for len(batch) < writeBatchSize {
+				select {
+				case job, ok := <-writeQueue:
+					if !ok {
+						break drain
+					}
+					batch = append(batch, job)
+				default:
+					break drain
+				}
+			}
+
+			writeLocked(func() {
+				for _, job := range batch {
+					job.apply()
+				}
+			})
+			for _, job := range batch {
+				if job.done != nil {
+					close(job.done)
+				}
+			}
+		}
+	}()
+}
+
+// applyWrite is called by POST handlers under the read lock, so the write
+// is only remembered and applied by commitWrite after the lock is released
+func applyWrite(ctx *fasthttp.RequestCtx, apply func()) {
+	ctx.SetUserValue(pendingWriteKey, apply)
+}
+
+// commitWrite applies the pending write according to the write mode, in queue mode a synchronous
+// write waits for all writes queued before it, so the client reads its own writes
+func commitWrite(ctx *fasthttp.RequestCtx) {
+	apply, ok := ctx.UserValue(pendingWriteKey).(func())
+	if !ok {
+		return
+	}
+	ctx.SetUserValue(pendingWriteKey, nil)
+
+	isSync := writeMode == writeModeSync || len(ctx.Request.Header.Peek(syncWriteHeader)) > 0
+
+	switch {
+	case writeQueue != nil:
+		job := writeJob{apply: apply}
+		if isSync {
+			job.done = make(chan struct{})
+		}
+		writeQueue <- job
+		if isSync {
+			<-job.done
+		}
+	case isSync:
+		writeLocked(apply)
+	default:
+		go writeLocked(apply)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/emirpasic/gods/maps/treemap"
+	"github.com/valyala/fasthttp"
+)
+
+func TestApplyWriteQueue(t *testing.T) {
+	defer func(mode string) {
+		writeMode = mode
+		close(writeQueue)
+		writeQueue = nil
+	}(writeMode)
+	writeMode = writeModeQueue
+	startWriter()
+
+	var applied []int
+	for i := 0; i < 100; i++ {
+		value := i
+		ctx := &fasthttp.RequestCtx{}
+		applyWrite(ctx, func() {
+			applied = append(applied, value)
+		})
+		commitWrite(ctx)
+	}
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.Set(syncWriteHeader, "1")
+	applyWrite(ctx, func() {})
+	commitWrite(ctx)
+
+	if len(applied) != 100 {
+		t.Fatalf("synchronous write returned before queued ones were applied: %d", len(applied))
+	}
+	for i, value := range applied {
+		if value != i {
+			t.Fatalf("writes were applied out of order: %v", applied)
+		}
+	}
+}
+
+func testRequest(method, uri, body string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	ctx.Request.SetBodyString(body)
+
+	return ctx
+}
+
+func TestConcurrentWritesAndQueries(t *testing.T) {
+	const firstId, count = 990001000, 50
+	defer func() {
+		writeLocked(func() {
+			for id := firstId; id < firstId+count; id++ {
+				if value, found := accountIndex.Get(id); found {
+					unindexAccount(value.(*Account))
+					accountIndex.Remove(id)
+				}
+			}
+		})
+	}()
+
+	defer func(mode string) {
+		writeMode = mode
+	}(writeMode)
+	writeMode = writeModeSync
+
+	var wg sync.WaitGroup
+	const readers = 4
+	wg.Add(1 + readers)
+	go func() {
+		defer wg.Done()
+		for id := firstId; id < firstId+count; id++ {
+			ctx := testRequest("POST", "/accounts/new/", fmt.Sprintf(
+				`{"id":%d,"email":"race%d@test.local","sex":"m","status":"заняты","interests":["race"]}`, id, id))
+			requestHandler(ctx)
+			if ctx.Response.StatusCode() != 201 {
+				t.Errorf("account %d was not created: %s", id, ctx.Response.Body())
+			}
+
+			ctx = testRequest("POST", fmt.Sprintf("/accounts/%d/", id), `{"status":"свободны"}`)
+			requestHandler(ctx)
+
+			ctx = testRequest("POST", "/accounts/likes/", fmt.Sprintf(
+				`{"likes":[{"liker":%d,"likee":%d,"ts":1500000000}]}`, id, firstId))
+			requestHandler(ctx)
+		}
+	}()
+	for r := 0; r < readers; r++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				for _, uri := range []string{
+					"/accounts/filter/?sex_eq=m&interests_contains=race&limit=5",
+					"/accounts/group/?keys=status&limit=5",
+					fmt.Sprintf("/accounts/%d/suggest/?limit=5", firstId),
+				} {
+					ctx := testRequest("GET", uri, "")
+					requestHandler(ctx)
+					// responses of parallel readers must not share buffers
+					if body := ctx.Response.Body(); ctx.Response.StatusCode() == 200 && !json.Valid(body) {
+						t.Errorf("%s: broken response %s", uri, body)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	readLocked(func() {
+		if created := interestsIndex.Get("race"); created == nil || created.(*treemap.Map).Size() != count {
+			t.Error("not all accounts were indexed")
+		}
+		if likeeIndex.Count(firstId) != count {
+			t.Errorf("expected %d likers, got %d", count, likeeIndex.Count(firstId))
+		}
+	})
+}